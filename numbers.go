@@ -0,0 +1,505 @@
+package borat
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// WriteBigInt writes an arbitrarily large integer to the output stream. Values
+// that fit in a CBOR integer are written as one; others are written as
+// bignums (tags 2 and 3). A nil b is written as nil.
+func (w *CBORWriter) WriteBigInt(b *big.Int) error {
+	if b == nil {
+		return w.WriteNil()
+	}
+
+	// negative values are encoded as -1 - n
+	n, mt, tag := b, byte(majorUnsigned), CBORTag(TagPosBignum)
+	if b.Sign() < 0 {
+		n, mt, tag = new(big.Int).Not(b), majorNegative, TagNegBignum
+	}
+
+	if n.IsUint64() {
+		return w.writeBasicInt(n.Uint64(), mt)
+	}
+
+	if err := w.WriteTag(tag); err != nil {
+		return err
+	}
+	return w.WriteBytes(n.Bytes())
+}
+
+// ReadBigInt reads an integer of arbitrary size from the CBOR reader. Both
+// plain integers and bignums (tags 2 and 3) are accepted.
+func (r *CBORReader) ReadBigInt() (*big.Int, error) {
+	t, u, _, err := r.PeekHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	if t == TypeTag {
+		tag := CBORTag(u)
+		if tag != TagPosBignum && tag != TagNegBignum {
+			return nil, r.wrongTag(tag, TagPosBignum, TagNegBignum)
+		}
+		if _, err := r.ReadTag(); err != nil {
+			return nil, err
+		}
+		return r.readBignum(tag)
+	}
+
+	u, _, neg, err := r.readBasicUnsigned(majorUnsigned)
+	if err != nil {
+		return nil, err
+	}
+	b := new(big.Int).SetUint64(u)
+	if neg {
+		b.Not(b)
+	}
+	return b, nil
+}
+
+// readBignum reads the byte string content of a bignum tag whose number has
+// already been read.
+func (r *CBORReader) readBignum(tag CBORTag) (*big.Int, error) {
+	start := r.offset()
+	buf, err := r.ReadBytes()
+	if errors.Is(err, CBORTypeReadError) {
+		return nil, r.invalid()
+	} else if err != nil {
+		return nil, err
+	}
+
+	// a bignum which fits an integer would be written as one
+	if r.strict && (len(buf) <= 8 || buf[0] == 0) {
+		return nil, r.canonicalError(start, "bignum not in shortest form")
+	}
+
+	b := new(big.Int).SetBytes(buf)
+	if tag == TagNegBignum {
+		b.Not(b)
+	}
+	return b, nil
+}
+
+// Decimal is an exact decimal number with the value Mantissa * 10^Exponent.
+// It is encoded as a decimal fraction (tag 4). A nil Mantissa is zero.
+type Decimal struct {
+	Mantissa *big.Int
+	Exponent int
+}
+
+// BigFloat is an exact binary floating point number with the value
+// Mantissa * 2^Exponent. It is encoded as a bigfloat (tag 5). A nil Mantissa
+// is zero.
+type BigFloat struct {
+	Mantissa *big.Int
+	Exponent int
+}
+
+// MarshalCBOR writes d as a decimal fraction.
+func (d Decimal) MarshalCBOR(w *CBORWriter) error {
+	return w.writeFraction(TagDecimal, d.Exponent, d.Mantissa)
+}
+
+// UnmarshalCBOR reads a decimal fraction into d.
+func (d *Decimal) UnmarshalCBOR(r *CBORReader) error {
+	exp, mant, err := r.readTaggedFraction(TagDecimal)
+	if err != nil {
+		return err
+	}
+	d.Mantissa, d.Exponent = mant, exp
+	return nil
+}
+
+// MarshalCBOR writes b as a bigfloat.
+func (b BigFloat) MarshalCBOR(w *CBORWriter) error {
+	return w.writeFraction(TagBigFloat, b.Exponent, b.Mantissa)
+}
+
+// UnmarshalCBOR reads a bigfloat into b.
+func (b *BigFloat) UnmarshalCBOR(r *CBORReader) error {
+	exp, mant, err := r.readTaggedFraction(TagBigFloat)
+	if err != nil {
+		return err
+	}
+	b.Mantissa, b.Exponent = mant, exp
+	return nil
+}
+
+// writeFraction writes a decimal fraction or bigfloat as the given tag
+// followed by an array of the exponent and mantissa.
+func (w *CBORWriter) writeFraction(tag CBORTag, exp int, mant *big.Int) error {
+	if mant == nil {
+		mant = new(big.Int)
+	}
+
+	if err := w.WriteTag(tag); err != nil {
+		return err
+	}
+	if err := w.writeBasicInt(2, majorArray); err != nil {
+		return err
+	}
+	if err := w.WriteInt(exp); err != nil {
+		return err
+	}
+	return w.WriteBigInt(mant)
+}
+
+// readTaggedFraction reads a decimal fraction or bigfloat, which must carry
+// the given tag.
+func (r *CBORReader) readTaggedFraction(want CBORTag) (int, *big.Int, error) {
+	if err := r.readWantedTag(want); err != nil {
+		return 0, nil, err
+	}
+	return r.readFraction()
+}
+
+// readFraction reads the exponent and mantissa array of a decimal fraction or
+// bigfloat whose tag has already been read.
+func (r *CBORReader) readFraction() (int, *big.Int, error) {
+	var exp int
+	var mant *big.Int
+	err := r.readPair(func(i int) (err error) {
+		if i == 0 {
+			exp, err = r.ReadInt()
+		} else {
+			mant, err = r.ReadBigInt()
+		}
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return exp, mant, nil
+}
+
+// readPair reads the two-element array that forms the content of a numeric
+// tag, calling read for each element in turn. Since the tag has already been
+// read, content of the wrong type is invalid CBOR.
+func (r *CBORReader) readPair(read func(i int) error) error {
+	n, err := r.readContainer(majorArray)
+	if errors.Is(err, CBORTypeReadError) {
+		return r.invalid()
+	} else if err != nil {
+		return err
+	}
+	if err := r.enter(); err != nil {
+		return err
+	}
+	defer r.leave()
+
+	for i := 0; i < 3; i++ {
+		more, err := r.hasMore(&n)
+		if err != nil {
+			return err
+		}
+		if more != (i < 2) {
+			return r.invalid()
+		}
+		if !more {
+			break
+		}
+
+		if err := read(i); errors.Is(err, CBORTypeReadError) {
+			return r.invalid()
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d Decimal) mantissa() *big.Int {
+	if d.Mantissa == nil {
+		return new(big.Int)
+	}
+	return d.Mantissa
+}
+
+func (b BigFloat) mantissa() *big.Int {
+	if b.Mantissa == nil {
+		return new(big.Int)
+	}
+	return b.Mantissa
+}
+
+// String formats d in decimal notation, switching to exponent notation
+// (e.g. "15e2") for positive or very negative exponents.
+func (d Decimal) String() string {
+	m := d.mantissa()
+	if d.Exponent == 0 {
+		return m.String()
+	}
+
+	digits := new(big.Int).Abs(m).String()
+	point := len(digits) + d.Exponent
+	if d.Exponent > 0 || point < -6 {
+		return m.String() + "e" + strconv.Itoa(d.Exponent)
+	}
+
+	var sb strings.Builder
+	if m.Sign() < 0 {
+		sb.WriteByte('-')
+	}
+	if point <= 0 {
+		sb.WriteString("0.")
+		sb.WriteString(strings.Repeat("0", -point))
+		sb.WriteString(digits)
+	} else {
+		sb.WriteString(digits[:point])
+		sb.WriteByte('.')
+		sb.WriteString(digits[point:])
+	}
+	return sb.String()
+}
+
+// ParseDecimal parses a number in decimal notation, with an optional fraction
+// and exponent, such as "-12.50" or "1.5e-3". The digits are kept exactly as
+// written, so "12.50" has the mantissa 1250 and the exponent -2.
+func ParseDecimal(s string) (Decimal, error) {
+	mant, exp := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+		}
+		mant, exp = s[:i], e
+	}
+
+	sign := ""
+	if len(mant) > 0 && (mant[0] == '-' || mant[0] == '+') {
+		sign, mant = mant[:1], mant[1:]
+	}
+	if i := strings.IndexByte(mant, '.'); i >= 0 {
+		exp -= len(mant) - i - 1
+		mant = mant[:i] + mant[i+1:]
+	}
+	if mant == "" || strings.TrimLeft(mant, "0123456789") != "" {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+
+	m, _ := new(big.Int).SetString(sign+mant, 10)
+	return Decimal{Mantissa: m, Exponent: exp}, nil
+}
+
+// maxExactExponent is the largest exponent, in magnitude, of a decimal
+// fraction or bigfloat which is scaled exactly. Exponents are read from the
+// input, and scaling by much larger ones would take unbounded time and memory.
+const maxExactExponent = 1024
+
+// Float returns d as a *big.Float rounded to prec bits of mantissa. If prec is
+// 0, 64 bits are used. Values beyond the range of big.Float become ±Inf or 0.
+func (d Decimal) Float(prec uint) *big.Float {
+	if prec == 0 {
+		prec = 64
+	}
+
+	m := d.mantissa()
+	if d.Exponent > maxExactExponent || d.Exponent < -maxExactExponent {
+		return d.scaledFloat(prec)
+	}
+	if d.Exponent >= 0 {
+		m = new(big.Int).Mul(m, pow10(d.Exponent))
+		return new(big.Float).SetPrec(prec).SetInt(m)
+	}
+
+	num := new(big.Float).SetInt(m)
+	den := new(big.Float).SetInt(pow10(-d.Exponent))
+	return new(big.Float).SetPrec(prec).Quo(num, den)
+}
+
+// scaledFloat returns d as a *big.Float rounded to prec bits of mantissa,
+// scaling by a power of ten computed with guard bits rather than exactly. The
+// power overflows to +Inf past the range of big.Float, so the result does too,
+// or underflows to 0.
+func (d Decimal) scaledFloat(prec uint) *big.Float {
+	m := d.mantissa()
+	if m.Sign() == 0 {
+		return new(big.Float).SetPrec(prec)
+	}
+
+	// the magnitude of the exponent, which may be math.MinInt
+	n := uint64(d.Exponent)
+	if d.Exponent < 0 {
+		n = uint64(-(d.Exponent + 1)) + 1
+	}
+	guard := prec + 64
+	p := new(big.Float).SetPrec(guard).SetInt64(1)
+	for x := new(big.Float).SetPrec(guard).SetInt64(10); n > 0; n >>= 1 {
+		if n&1 == 1 {
+			p.Mul(p, x)
+		}
+		x.Mul(x, x)
+	}
+
+	f := new(big.Float).SetPrec(guard).SetInt(m)
+	if d.Exponent > 0 {
+		f.Mul(f, p)
+	} else {
+		f.Quo(f, p)
+	}
+	return new(big.Float).SetPrec(prec).Set(f)
+}
+
+// DecimalFromFloat returns the exact decimal value of f. f must be finite.
+func DecimalFromFloat(f *big.Float) (Decimal, error) {
+	b, err := BigFloatFromFloat(f)
+	if err != nil {
+		return Decimal{}, err
+	}
+	return b.decimal(), nil
+}
+
+// decimal returns the exact decimal value of b, without trailing zeros in the
+// mantissa.
+func (b BigFloat) decimal() Decimal {
+	m := new(big.Int).Set(b.mantissa())
+	exp := b.Exponent
+	if exp >= 0 {
+		m.Lsh(m, uint(exp))
+		exp = 0
+	} else {
+		// m * 2^exp == m * 5^-exp * 10^exp
+		m.Mul(m, new(big.Int).Exp(big.NewInt(5), big.NewInt(int64(-exp)), nil))
+	}
+
+	if m.Sign() == 0 {
+		return Decimal{Mantissa: m}
+	}
+	ten, rem := big.NewInt(10), new(big.Int)
+	for {
+		q, r := new(big.Int).QuoRem(m, ten, rem)
+		if r.Sign() != 0 {
+			break
+		}
+		m = q
+		exp++
+	}
+	return Decimal{Mantissa: m, Exponent: exp}
+}
+
+// String formats b as an exact decimal number, or as "m*2^e" if the exponent
+// is too large for that to be practical.
+func (b BigFloat) String() string {
+	if b.Exponent > maxExactExponent || b.Exponent < -maxExactExponent {
+		return b.mantissa().String() + "*2^" + strconv.Itoa(b.Exponent)
+	}
+	return b.decimal().String()
+}
+
+// ParseBigFloat parses a number in decimal notation as accepted by
+// ParseDecimal. It fails if the number has no exact binary representation,
+// as is the case for "0.1".
+func ParseBigFloat(s string) (BigFloat, error) {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		return BigFloat{}, err
+	}
+
+	m, exp := new(big.Int).Set(d.Mantissa), d.Exponent
+	if exp >= 0 {
+		m.Mul(m, pow10(exp))
+		exp = 0
+	} else {
+		// m * 10^exp == m / 5^-exp * 2^exp, which is exact only if 5^-exp
+		// divides m
+		p := new(big.Int).Exp(big.NewInt(5), big.NewInt(int64(-exp)), nil)
+		q, r := new(big.Int).QuoRem(m, p, new(big.Int))
+		if r.Sign() != 0 {
+			return BigFloat{}, fmt.Errorf("decimal %q has no exact binary representation", s)
+		}
+		m = q
+	}
+
+	if m.Sign() == 0 {
+		return BigFloat{Mantissa: m}, nil
+	}
+	shift := m.TrailingZeroBits()
+	m.Rsh(m, shift)
+	return BigFloat{Mantissa: m, Exponent: exp + int(shift)}, nil
+}
+
+// Float returns the exact value of b as a *big.Float.
+func (b BigFloat) Float() *big.Float {
+	f := new(big.Float).SetInt(b.mantissa())
+	return f.SetMantExp(f, b.Exponent)
+}
+
+// BigFloatFromFloat returns the exact value of f as a BigFloat. f must be
+// finite.
+func BigFloatFromFloat(f *big.Float) (BigFloat, error) {
+	if f.IsInf() {
+		return BigFloat{}, errors.New("cannot represent infinity as a bigfloat")
+	}
+	if f.Sign() == 0 {
+		return BigFloat{Mantissa: new(big.Int)}, nil
+	}
+
+	// scale the mantissa in [0.5, 1) up to an integer
+	mant := new(big.Float)
+	exp := f.MantExp(mant)
+	prec := int(mant.MinPrec())
+	mant.SetMantExp(mant, prec)
+	m, _ := mant.Int(nil)
+	return BigFloat{Mantissa: m, Exponent: exp - prec}, nil
+}
+
+// pow10 returns 10^n for n >= 0.
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// WriteRat writes a rational number to the output stream as tag 30 applied to
+// an array of the numerator and denominator. A nil x is written as nil.
+func (w *CBORWriter) WriteRat(x *big.Rat) error {
+	if x == nil {
+		return w.WriteNil()
+	}
+
+	if err := w.WriteTag(TagRational); err != nil {
+		return err
+	}
+	if err := w.writeBasicInt(2, majorArray); err != nil {
+		return err
+	}
+	if err := w.WriteBigInt(x.Num()); err != nil {
+		return err
+	}
+	return w.WriteBigInt(x.Denom())
+}
+
+// ReadRat reads a rational number (tag 30) from the CBOR reader.
+func (r *CBORReader) ReadRat() (*big.Rat, error) {
+	if err := r.readWantedTag(TagRational); err != nil {
+		return nil, err
+	}
+	return r.readRational()
+}
+
+// readRational reads the numerator and denominator array of a rational number
+// whose tag has already been read. The denominator must be positive.
+func (r *CBORReader) readRational() (*big.Rat, error) {
+	var num, den *big.Int
+	err := r.readPair(func(i int) (err error) {
+		if i == 0 {
+			num, err = r.ReadBigInt()
+		} else {
+			den, err = r.ReadBigInt()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if den.Sign() <= 0 {
+		return nil, r.invalid()
+	}
+
+	return new(big.Rat).SetFrac(num, den), nil
+}
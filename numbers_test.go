@@ -0,0 +1,357 @@
+package borat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func bigIntFromString(s string) *big.Int {
+	b, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid big integer " + s)
+	}
+	return b
+}
+
+func TestBigInt(t *testing.T) {
+	testPatterns := []struct {
+		value string
+		cbor  []byte
+	}{
+		{"0", []byte{0x00}},
+		{"-1", []byte{0x20}},
+		{"18446744073709551615", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"18446744073709551616", []byte{0xc2, 0x49, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{"-18446744073709551616", []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"-18446744073709551617", []byte{0xc3, 0x49, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+
+	for _, tp := range testPatterns {
+		want := bigIntFromString(tp.value)
+
+		var buf bytes.Buffer
+		if err := NewCBORWriter(&buf).Marshal(want); err != nil {
+			t.Errorf("failed to marshal %v: %v", want, err)
+		} else if !bytes.Equal(buf.Bytes(), tp.cbor) {
+			t.Errorf("error writing %v: expected [% x], got [% x]", want, tp.cbor, buf.Bytes())
+		}
+
+		var got big.Int
+		r := NewCBORReader(bytes.NewReader(tp.cbor))
+		if err := r.Unmarshal(&got); err != nil {
+			t.Errorf("failed to unmarshal % x: %v", tp.cbor, err)
+		} else if got.Cmp(want) != 0 {
+			t.Errorf("unmarshaling % x: want %v, got %v", tp.cbor, want, &got)
+		}
+	}
+
+	// Read returns *big.Int for bignums and for negative integers below
+	// the range of int.
+	for _, tp := range testPatterns[3:] {
+		r := NewCBORReader(bytes.NewReader(tp.cbor))
+		v, err := r.Read()
+		if err != nil {
+			t.Errorf("failed to read % x: %v", tp.cbor, err)
+		} else if b, ok := v.(*big.Int); !ok || b.Cmp(bigIntFromString(tp.value)) != 0 {
+			t.Errorf("reading % x: want %v, got %#v", tp.cbor, tp.value, v)
+		}
+	}
+
+	r := NewCBORReader(bytes.NewReader([]byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}))
+	if _, err := r.ReadInt(); !errors.Is(err, OverflowReadError) {
+		t.Errorf("expected overflow reading int but got %v", err)
+	}
+}
+
+func TestDecimalAndBigFloat(t *testing.T) {
+	testPatterns := []struct {
+		value interface{}
+		cbor  []byte
+	}{
+		{
+			// 273.15
+			Decimal{Mantissa: big.NewInt(27315), Exponent: -2},
+			[]byte{0xc4, 0x82, 0x21, 0x19, 0x6a, 0xb3},
+		},
+		{
+			// 1.5
+			BigFloat{Mantissa: big.NewInt(3), Exponent: -1},
+			[]byte{0xc5, 0x82, 0x20, 0x03},
+		},
+		{
+			Decimal{Mantissa: bigIntFromString("-18446744073709551617"), Exponent: 3},
+			[]byte{0xc4, 0x82, 0x03, 0xc3, 0x49, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+	}
+
+	for _, tp := range testPatterns {
+		var buf bytes.Buffer
+		if err := NewCBORWriter(&buf).Marshal(tp.value); err != nil {
+			t.Errorf("failed to marshal %v: %v", tp.value, err)
+		} else if !bytes.Equal(buf.Bytes(), tp.cbor) {
+			t.Errorf("error writing %v: expected [% x], got [% x]", tp.value, tp.cbor, buf.Bytes())
+		}
+
+		r := NewCBORReader(bytes.NewReader(tp.cbor))
+		v, err := r.Read()
+		if err != nil {
+			t.Errorf("failed to read % x: %v", tp.cbor, err)
+		} else if fmt.Sprint(v) != fmt.Sprint(tp.value) {
+			t.Errorf("reading % x: want %v, got %v", tp.cbor, tp.value, v)
+		}
+	}
+
+	var d Decimal
+	r := NewCBORReader(bytes.NewReader(testPatterns[0].cbor))
+	if err := r.Unmarshal(&d); err != nil {
+		t.Errorf("failed to unmarshal decimal: %v", err)
+	} else if f, _ := d.Float(53).Float64(); f != 273.15 {
+		t.Errorf("unexpected value unmarshaling decimal: %v", f)
+	}
+
+	var bf BigFloat
+	r = NewCBORReader(bytes.NewReader(testPatterns[0].cbor))
+	if err := r.Unmarshal(&bf); err == nil {
+		t.Errorf("expected error unmarshaling decimal into bigfloat")
+	}
+}
+
+func TestDecimalStrings(t *testing.T) {
+	testPatterns := []struct {
+		in   string
+		mant int64
+		exp  int
+		out  string
+	}{
+		{"273.15", 27315, -2, "273.15"},
+		{"-0.001", -1, -3, "-0.001"},
+		{"12.50", 1250, -2, "12.50"},
+		{"1.5e3", 15, 2, "15e2"},
+		{"+7E-10", 7, -10, "7e-10"},
+		{"42", 42, 0, "42"},
+	}
+	for _, tp := range testPatterns {
+		d, err := ParseDecimal(tp.in)
+		if err != nil {
+			t.Errorf("failed to parse %q: %v", tp.in, err)
+			continue
+		}
+		if d.Mantissa.Int64() != tp.mant || d.Exponent != tp.exp {
+			t.Errorf("parsing %q: want %de%d, got %ve%d", tp.in, tp.mant, tp.exp, d.Mantissa, d.Exponent)
+		}
+		if s := d.String(); s != tp.out {
+			t.Errorf("formatting %q: want %q, got %q", tp.in, tp.out, s)
+		}
+	}
+	for _, s := range []string{"", "-", "1.2.3", "1e", "0x10", "1_000"} {
+		if _, err := ParseDecimal(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+
+	d, err := DecimalFromFloat(big.NewFloat(0.1))
+	if err != nil {
+		t.Errorf("failed to convert 0.1 to decimal: %v", err)
+	} else if s := d.String(); s != "0.1000000000000000055511151231257827021181583404541015625" {
+		t.Errorf("unexpected exact decimal value of 0.1: %s", s)
+	}
+
+	b, err := ParseBigFloat("-2.75")
+	if err != nil {
+		t.Errorf("failed to parse bigfloat: %v", err)
+	} else if b.Mantissa.Int64() != -11 || b.Exponent != -2 || b.String() != "-2.75" {
+		t.Errorf("unexpected bigfloat parsing -2.75: %ve%d", b.Mantissa, b.Exponent)
+	} else if f, _ := b.Float().Float64(); f != -2.75 {
+		t.Errorf("unexpected float value of bigfloat: %v", f)
+	}
+	if _, err := ParseBigFloat("0.1"); err == nil {
+		t.Errorf("expected error parsing 0.1 as a bigfloat")
+	}
+
+	b, err = BigFloatFromFloat(big.NewFloat(1.5))
+	if err != nil || b.Mantissa.Int64() != 3 || b.Exponent != -1 {
+		t.Errorf("unexpected bigfloat from 1.5: %v, %v", b, err)
+	}
+}
+
+func TestWrongNumericTag(t *testing.T) {
+	// a tag of the wrong number is reported at its offset and left unread
+	read := []struct {
+		name string
+		read func(r *CBORReader) error
+	}{
+		{"ReadBigInt", func(r *CBORReader) error { _, err := r.ReadBigInt(); return err }},
+		{"ReadRat", func(r *CBORReader) error { _, err := r.ReadRat(); return err }},
+		{"Decimal", func(r *CBORReader) error { return new(Decimal).UnmarshalCBOR(r) }},
+		{"BigFloat", func(r *CBORReader) error { return new(BigFloat).UnmarshalCBOR(r) }},
+	}
+	// 1, 99(0)
+	in := []byte{0x01, 0xd8, 0x63, 0x00}
+	for _, tc := range read {
+		r := NewCBORReader(bytes.NewReader(in))
+		if err := r.Skip(); err != nil {
+			t.Fatalf("failed to skip first item: %v", err)
+		}
+		err := tc.read(r)
+		var de *DecodeError
+		if !errors.As(err, &de) || !errors.Is(err, CBORTypeReadError) || de.Offset != 1 || de.Type != TypeTag {
+			t.Errorf("%s: expected type error for tag 99 at offset 1 but got %v", tc.name, err)
+		}
+		if tag, err := r.ReadTag(); err != nil || tag != 99 {
+			t.Errorf("%s: expected tag 99 to be left unread but got %v, %v", tc.name, tag, err)
+		}
+	}
+}
+
+func TestHugeExponents(t *testing.T) {
+	// {5([2147483648, 1]): 0}: formatting the key must not expand 2^2^31
+	in := []byte{0xa1, 0xc5, 0x82, 0x1a, 0x80, 0x00, 0x00, 0x00, 0x01, 0x00}
+	m, err := NewCBORReader(bytes.NewReader(in)).ReadStringMap()
+	if err != nil {
+		t.Fatalf("failed to read map with bigfloat key: %v", err)
+	}
+	if _, ok := m["1*2^2147483648"]; !ok {
+		t.Errorf("unexpected keys for bigfloat key: %v", m)
+	}
+	if s := (BigFloat{Mantissa: big.NewInt(-3), Exponent: -5000}).String(); s != "-3*2^-5000" {
+		t.Errorf("unexpected bigfloat with large negative exponent: %s", s)
+	}
+
+	testPatterns := []struct {
+		d   Decimal
+		out string
+	}{
+		{Decimal{Mantissa: big.NewInt(15), Exponent: 2000}, "1.5e+2001"},
+		{Decimal{Mantissa: big.NewInt(-25), Exponent: -3001}, "-2.5e-3000"},
+		{Decimal{Mantissa: big.NewInt(1), Exponent: math.MaxInt}, "+Inf"},
+		{Decimal{Mantissa: big.NewInt(-1), Exponent: math.MaxInt}, "-Inf"},
+		{Decimal{Mantissa: big.NewInt(1), Exponent: math.MinInt}, "0"},
+		{Decimal{Exponent: math.MaxInt}, "0"},
+	}
+	for _, tp := range testPatterns {
+		if s := tp.d.Float(53).Text('g', 10); s != tp.out {
+			t.Errorf("unexpected float value of %v: want %s, got %s", tp.d, tp.out, s)
+		}
+	}
+}
+
+func TestRational(t *testing.T) {
+	testPatterns := []struct {
+		value *big.Rat
+		cbor  []byte
+	}{
+		{big.NewRat(1, 3), []byte{0xd8, 0x1e, 0x82, 0x01, 0x03}},
+		{big.NewRat(-44100, 1), []byte{0xd8, 0x1e, 0x82, 0x39, 0xac, 0x43, 0x01}},
+		{
+			new(big.Rat).SetFrac(big.NewInt(1), bigIntFromString("18446744073709551616")),
+			[]byte{0xd8, 0x1e, 0x82, 0x01, 0xc2, 0x49, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+	}
+
+	for _, tp := range testPatterns {
+		var buf bytes.Buffer
+		if err := NewCBORWriter(&buf).Marshal(tp.value); err != nil {
+			t.Errorf("failed to marshal %v: %v", tp.value, err)
+		} else if !bytes.Equal(buf.Bytes(), tp.cbor) {
+			t.Errorf("error writing %v: expected [% x], got [% x]", tp.value, tp.cbor, buf.Bytes())
+		}
+
+		r := NewCBORReader(bytes.NewReader(tp.cbor))
+		if v, err := r.Read(); err != nil {
+			t.Errorf("failed to read % x: %v", tp.cbor, err)
+		} else if x, ok := v.(*big.Rat); !ok || x.Cmp(tp.value) != 0 {
+			t.Errorf("reading % x: want %v, got %v", tp.cbor, tp.value, v)
+		}
+
+		var x big.Rat
+		r = NewCBORReader(bytes.NewReader(tp.cbor))
+		if err := r.Unmarshal(&x); err != nil {
+			t.Errorf("failed to unmarshal % x: %v", tp.cbor, err)
+		} else if x.Cmp(tp.value) != 0 {
+			t.Errorf("unmarshaling % x: want %v, got %v", tp.cbor, tp.value, &x)
+		}
+	}
+
+	// zero and negative denominators, and malformed content
+	invalid := [][]byte{
+		{0xd8, 0x1e, 0x82, 0x01, 0x00},
+		{0xd8, 0x1e, 0x82, 0x01, 0x20},
+		{0xd8, 0x1e, 0x83, 0x01, 0x02, 0x03},
+		{0xd8, 0x1e, 0x61, 0x61},
+	}
+	for _, b := range invalid {
+		r := NewCBORReader(bytes.NewReader(b))
+		if _, err := r.Read(); !errors.Is(err, InvalidCBORError) {
+			t.Errorf("expected invalid CBOR reading % x but got %v", b, err)
+		}
+	}
+}
+
+func TestNumericKinds(t *testing.T) {
+	testPatterns := []struct {
+		value interface{}
+		cbor  []byte
+	}{
+		{int8(-128), []byte{0x38, 0x7f}},
+		{int16(300), []byte{0x19, 0x01, 0x2c}},
+		{int32(-65537), []byte{0x3a, 0x00, 0x01, 0x00, 0x00}},
+		{int64(math.MinInt64), []byte{0x3b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{uint(7), []byte{0x07}},
+		{uint8(255), []byte{0x18, 0xff}},
+		{uint16(65535), []byte{0x19, 0xff, 0xff}},
+		{uint32(math.MaxUint32), []byte{0x1a, 0xff, 0xff, 0xff, 0xff}},
+		{uint64(math.MaxUint64), []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{uintptr(1), []byte{0x01}},
+		{float32(1.5), []byte{0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{float64(-2.25), []byte{0xfb, 0xc0, 0x02, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, tp := range testPatterns {
+		var out bytes.Buffer
+		if err := NewCBORWriter(&out).Marshal(tp.value); err != nil {
+			t.Errorf("failed to marshal %T %v: %v", tp.value, tp.value, err)
+		} else if !bytes.Equal(out.Bytes(), tp.cbor) {
+			t.Errorf("marshaling %T %v: expected % x, got % x", tp.value, tp.value, tp.cbor, out.Bytes())
+		}
+
+		got := reflect.New(reflect.TypeOf(tp.value))
+		if err := NewCBORReader(bytes.NewReader(tp.cbor)).Unmarshal(got.Interface()); err != nil {
+			t.Errorf("failed to unmarshal % x into %T: %v", tp.cbor, tp.value, err)
+		} else if got.Elem().Interface() != tp.value {
+			t.Errorf("unmarshaling % x: expected %T %v, got %v", tp.cbor, tp.value, tp.value, got.Elem())
+		}
+	}
+
+	// values which do not fit are range errors, not truncated
+	for _, tc := range []struct {
+		cbor []byte
+		out  interface{}
+	}{
+		{[]byte{0x18, 0x80}, new(int8)},
+		{[]byte{0x38, 0x80}, new(int8)},
+		{[]byte{0x19, 0x01, 0x00}, new(uint8)},
+		{[]byte{0x1a, 0x00, 0x01, 0x00, 0x00}, new(uint16)},
+		{[]byte{0x20}, new(uint)},
+		{[]byte{0x1b, 0x80, 0, 0, 0, 0, 0, 0, 0}, new(int64)},
+		{[]byte{0xfb, 0x7e, 0x37, 0xe4, 0x3c, 0x88, 0x00, 0x75, 0x9c}, new(float32)},
+	} {
+		err := NewCBORReader(bytes.NewReader(tc.cbor)).Unmarshal(tc.out)
+		var de *DecodeError
+		if !errors.As(err, &de) || !errors.Is(err, OverflowReadError) || de.Offset != 0 {
+			t.Errorf("expected range error reading % x into %T but got %v", tc.cbor, tc.out, err)
+		}
+	}
+
+	if _, err := NewCBORReader(bytes.NewReader([]byte{0x20})).ReadUint(); !errors.Is(err, OverflowReadError) {
+		t.Errorf("expected ReadUint of -1 to overflow but got %v", err)
+	}
+
+	if err := NewCBORWriter(new(bytes.Buffer)).Marshal(complex(1, 2)); err == nil {
+		t.Errorf("expected error marshaling complex number")
+	}
+}
@@ -1,62 +1,540 @@
 package borat
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/big"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var (
+	// ShortReadError is no longer returned by CBORReader.
+	//
+	// Deprecated: input that ends in the middle of a data item now results in
+	// io.ErrUnexpectedEOF.
 	ShortReadError    = errors.New("short read")
 	CBORTypeReadError = errors.New("invalid CBOR type for typed read")
 	InvalidCBORError  = errors.New("invalid CBOR")
 	// UnsupportedTypeReadError is an explicit error for types we do not support.
 	// This is different to encountering something which is not in the RFC.
 	UnsupportedTypeReadError = errors.New("unsupported type encountered in read")
+	// OverflowReadError is returned when a value does not fit the Go type it
+	// is being read into.
+	OverflowReadError = errors.New("value overflows type in read")
 )
 
+// DecodeError describes a failure to decode the input. It wraps one of the
+// errors above, or the error from the input stream, so it can be tested for
+// with errors.Is.
+type DecodeError struct {
+	// Offset is the input offset at which the error was detected. For type
+	// mismatches, it is the offset of the mismatched data item.
+	Offset int64
+	// Type is the type of the data item found, and Expected the type that
+	// was wanted, if known.
+	Type     CBORType
+	Expected CBORType
+	// Path is the Go value being filled by Unmarshal when the error
+	// occurred, such as "Zone.Assertions[3].Content".
+	Path string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.Err.Error())
+	if e.Type != TypeNone {
+		sb.WriteString(": found ")
+		sb.WriteString(e.Type.String())
+		if e.Expected != TypeNone {
+			sb.WriteString(", expected ")
+			sb.WriteString(e.Expected.String())
+		}
+	}
+	sb.WriteString(" at offset ")
+	sb.WriteString(strconv.FormatInt(e.Offset, 10))
+	if e.Path != "" {
+		sb.WriteString(" in ")
+		sb.WriteString(e.Path)
+	}
+	return sb.String()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// LimitError reports that the input exceeds one of the limits set in
+// DecOptions. It is returned wrapped in a DecodeError, before any memory is
+// allocated for the offending data item.
+type LimitError struct {
+	// Limit is the name of the DecOptions field, such as "MaxDepth".
+	Limit string
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s of %d exceeded in read", e.Limit, e.Max)
+}
+
+// CanonicalError reports that the input is well-formed but breaks one of the
+// rules of canonical encoding checked when DecOptions.Strict is set. It is
+// returned wrapped in a DecodeError.
+type CanonicalError struct {
+	// Rule describes the rule broken, such as "non-minimal header".
+	Rule string
+}
+
+func (e *CanonicalError) Error() string {
+	return "non-canonical CBOR in read: " + e.Rule
+}
+
+// DuplicateKeyError reports that a map key appears more than once, when
+// DecOptions.DupKeys is DupKeyReject. It is returned wrapped in a DecodeError
+// at the offset of the second occurrence of the key.
+type DuplicateKeyError struct {
+	Key interface{}
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("duplicate map key %v in read", e.Key)
+}
+
+// DupKeyPolicy selects how a CBORReader handles a map key which appears more
+// than once. Keys are compared after conversion to the type of the Go map or
+// struct field they select, so that the keys 1 and "1" of a map read by
+// ReadStringMap are duplicates.
+type DupKeyPolicy int
+
+const (
+	// DupKeyKeepLast keeps the value of the last occurrence of a key.
+	DupKeyKeepLast DupKeyPolicy = iota
+	// DupKeyKeepFirst keeps the value of the first occurrence of a key.
+	DupKeyKeepFirst
+	// DupKeyReject fails with a DuplicateKeyError.
+	DupKeyReject
+)
+
+// DecOptions configures how a CBORReader checks its input. The limits protect
+// against hostile messages declaring huge lengths or deep nesting. A zero
+// limit selects the default; a negative limit disables the check.
+type DecOptions struct {
+	// Strict rejects input that is not in the canonical form of RFC 8949
+	// section 4.2.1, so that re-encoding a value read gives the same bytes:
+	// integers, lengths and tags must use the shortest header, lengths must
+	// be definite, map keys must be unique and sorted in the bytewise order
+	// of their encodings, floats must be in the shortest form that preserves
+	// their value with NaN as 0xf97e00, and bignums must be used only for
+	// values which do not fit an integer, without leading zero bytes.
+	Strict bool
+	// DupKeys selects how duplicate map keys are handled.
+	DupKeys DupKeyPolicy
+	// AllowInvalidUTF8 accepts text strings which are not valid UTF-8,
+	// instead of failing with an InvalidUTF8Error.
+	AllowInvalidUTF8 bool
+	// AllowUntagged accepts a struct which declares a tag with its cborTag
+	// field from an untagged map. The tag is always checked if present.
+	AllowUntagged bool
+	// MaxDepth limits the nesting of arrays, maps, tags and
+	// indefinite-length strings. The default is 32.
+	MaxDepth int
+	// MaxArrayLen limits the number of elements in an array. The default is
+	// 131072.
+	MaxArrayLen int
+	// MaxMapLen limits the number of key-value pairs in a map. The default is
+	// 131072.
+	MaxMapLen int
+	// MaxStringLen limits the length of a byte or text string, counting all
+	// the chunks of an indefinite-length string. There is no default limit.
+	MaxStringLen int
+	// MaxBytes limits the encoded size of each top-level data item. There is
+	// no default limit.
+	MaxBytes int64
+}
+
+const (
+	defaultMaxDepth    = 32
+	defaultMaxArrayLen = 131072
+	defaultMaxMapLen   = 131072
+)
+
+// limit returns the effective value of a limit set to n with default def.
+func limit(n int64, def int64) int64 {
+	switch {
+	case n < 0:
+		return math.MaxInt64
+	case n == 0 && def > 0:
+		return def
+	case n == 0:
+		return math.MaxInt64
+	default:
+		return n
+	}
+}
+
+// readBufSize is the size of the input buffer of a CBORReader.
+const readBufSize = 4096
+
+// maxPrealloc bounds the capacity preallocated for a container, so that
+// memory is only committed to elements as they arrive.
+const maxPrealloc = 1024
+
+// CBORReader reads CBOR from an input stream. It buffers its input, and may
+// therefore read past the end of the last data item it returns; see Buffered.
+//
+// When the input ends cleanly between two data items, the next read returns
+// io.EOF. When it ends in the middle of a data item, the read returns
+// io.ErrUnexpectedEOF.
 type CBORReader struct {
-	in       io.Reader
-	pushback byte
-	pushed   bool
+	in     io.Reader
+	buf    []byte // r.buf[r.pos:r.end] is buffered but not yet consumed
+	pos    int
+	end    int
+	off    int64 // input offset of r.buf[0]
+	depth  int   // number of containers being read
+	tagged bool  // a tag has been read but not its content
+	start  int64 // input offset of the current top-level data item
+
+	strict    bool
+	dupKeys   DupKeyPolicy
+	anyUTF8   bool
+	untagged  bool
+	recording int    // number of open recordings
+	rec       []byte // bytes consumed while recording
+
+	maxDepth     int64
+	maxArrayLen  int64
+	maxMapLen    int64
+	maxStringLen int64
+	maxBytes     int64
+
+	path     []pathElem // Go value being filled by Unmarshal
+	scsCache map[reflect.Type]*structCBORSpec
+	tokens   []tokenContainer // containers being walked with Token
 }
 
+// NewCBORReader creates a new CBORReader around a given input stream, with
+// the default limits of DecOptions.
 func NewCBORReader(in io.Reader) *CBORReader {
-	r := new(CBORReader)
-	r.in = in
+	return NewCBORReaderWithOptions(in, DecOptions{})
+}
+
+// NewCBORReaderWithOptions creates a new CBORReader around a given input
+// stream, limiting its input as configured by opts.
+func NewCBORReaderWithOptions(in io.Reader, opts DecOptions) *CBORReader {
+	r := &CBORReader{
+		in:           in,
+		buf:          make([]byte, readBufSize),
+		strict:       opts.Strict,
+		dupKeys:      opts.DupKeys,
+		anyUTF8:      opts.AllowInvalidUTF8,
+		untagged:     opts.AllowUntagged,
+		maxDepth:     limit(int64(opts.MaxDepth), defaultMaxDepth),
+		maxArrayLen:  limit(int64(opts.MaxArrayLen), defaultMaxArrayLen),
+		maxMapLen:    limit(int64(opts.MaxMapLen), defaultMaxMapLen),
+		maxStringLen: limit(int64(opts.MaxStringLen), 0),
+		maxBytes:     limit(opts.MaxBytes, 0),
+		scsCache:     make(map[reflect.Type]*structCBORSpec),
+	}
 	return r
 }
 
+// Buffered returns a reader of the data remaining in the CBORReader's buffer,
+// which has been read from the input stream but not yet consumed.
+func (r *CBORReader) Buffered() io.Reader {
+	return bytes.NewReader(r.buf[r.pos:r.end])
+}
+
+// fill ensures that at least n bytes are buffered, reading from the input
+// stream as necessary. It returns io.EOF if the input ends before any more
+// bytes could be read. n must not exceed len(r.buf).
+func (r *CBORReader) fill(n int) error {
+	if r.end-r.pos >= n {
+		return nil
+	}
+
+	// move unconsumed bytes to the start of the buffer to make room
+	if r.pos+n > len(r.buf) {
+		copy(r.buf, r.buf[r.pos:r.end])
+		r.off += int64(r.pos)
+		r.end -= r.pos
+		r.pos = 0
+	}
+
+	m, err := io.ReadAtLeast(r.in, r.buf[r.end:], n-(r.end-r.pos))
+	r.end += m
+	if r.end-r.pos >= n {
+		return nil
+	}
+	return err
+}
+
+// offset returns the input offset of the next byte to be consumed.
+func (r *CBORReader) offset() int64 {
+	return r.off + int64(r.pos)
+}
+
+// errorAt wraps err in a DecodeError at the current input offset.
+func (r *CBORReader) errorAt(err error) *DecodeError {
+	return &DecodeError{Offset: r.offset(), Path: r.pathString(), Err: err}
+}
+
+// typeError reports that the data item with the initial byte ct, which must
+// have been pushed back, is not of the expected type.
+func (r *CBORReader) typeError(ct byte, expected CBORType) error {
+	return r.wrongType(typeOf(ct), expected)
+}
+
+// wrongType reports that the next data item, of type found, is not of the
+// expected type.
+func (r *CBORReader) wrongType(found, expected CBORType) error {
+	e := r.errorAt(CBORTypeReadError)
+	e.Type = found
+	e.Expected = expected
+	return e
+}
+
+// wrongTag reports that the next data item, which must not have been consumed,
+// is tagged with tag rather than one of want.
+func (r *CBORReader) wrongTag(tag CBORTag, want ...CBORTag) error {
+	expected := make([]string, len(want))
+	for i, w := range want {
+		expected[i] = strconv.FormatUint(uint64(w), 10)
+	}
+	e := r.errorAt(fmt.Errorf("%w: tag %d, expected %s", CBORTypeReadError, tag, strings.Join(expected, " or ")))
+	e.Type = TypeTag
+	return e
+}
+
+// readWantedTag reads the tag number of the next data item, which must be
+// want. Otherwise the tag is left unread.
+func (r *CBORReader) readWantedTag(want CBORTag) error {
+	t, u, _, err := r.PeekHeader()
+	if err != nil {
+		return err
+	}
+	if t != TypeTag {
+		return r.wrongType(t, TypeTag)
+	}
+	if CBORTag(u) != want {
+		return r.wrongTag(CBORTag(u), want)
+	}
+	_, err = r.ReadTag()
+	return err
+}
+
+// invalid reports that the input is not well-formed CBOR.
+func (r *CBORReader) invalid() error {
+	return r.errorAt(InvalidCBORError)
+}
+
+// limitError reports that the data item at offset start exceeds the limit
+// named name, whose value is max.
+func (r *CBORReader) limitError(start int64, name string, max int64) error {
+	e := r.errorAt(&LimitError{Limit: name, Max: max})
+	e.Offset = start
+	return e
+}
+
+// canonicalError reports that the data item at offset start breaks the
+// canonical encoding rule described by rule.
+func (r *CBORReader) canonicalError(start int64, rule string) error {
+	e := r.errorAt(&CanonicalError{Rule: rule})
+	e.Offset = start
+	return e
+}
+
+// startRecord starts recording the input consumed, and returns a mark to pass
+// to stopRecord. Recordings may be nested.
+func (r *CBORReader) startRecord() int {
+	r.recording++
+	return len(r.rec)
+}
+
+// stopRecord stops the recording started at mark and returns the input
+// consumed since. The returned slice is only valid until the next read.
+func (r *CBORReader) stopRecord(mark int) []byte {
+	b := r.rec[mark:]
+	r.recording--
+	if r.recording == 0 {
+		r.rec = r.rec[:0]
+	}
+	return b
+}
+
+// checkBytes fails if consuming the next n bytes of input would exceed
+// MaxBytes for the current top-level data item.
+func (r *CBORReader) checkBytes(n uint64) error {
+	if r.maxBytes == math.MaxInt64 {
+		return nil
+	}
+	if n > uint64(r.maxBytes-(r.offset()-r.start)) {
+		return r.limitError(r.start, "MaxBytes", r.maxBytes)
+	}
+	return nil
+}
+
+// unexpected converts io.EOF to io.ErrUnexpectedEOF, for reads which take
+// place in the middle of a data item.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readType consumes and returns the initial byte of a data item.
 func (r *CBORReader) readType() (byte, error) {
-	b := make([]byte, 1)
-	if r.pushed {
-		b[0] = r.pushback
-		r.pushed = false
-	} else {
-		n, err := r.in.Read(b)
-		if n != 1 {
-			return 0, ShortReadError
-		} else if err != nil {
+	if r.depth == 0 && !r.tagged {
+		r.start = r.offset()
+	}
+	if err := r.checkBytes(1); err != nil {
+		return 0, err
+	}
+
+	if r.pos == r.end {
+		err := r.fill(1)
+		if err == io.EOF && (r.depth > 0 || r.tagged) {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == io.EOF {
 			return 0, err
+		} else if err != nil {
+			return 0, r.errorAt(err)
 		}
 	}
 
-	return b[0], nil
+	ct := r.buf[r.pos]
+	r.pos++
+	r.tagged = false
+	if r.recording > 0 {
+		r.rec = append(r.rec, ct)
+	}
+	return ct, nil
 }
 
+// pushbackType returns the initial byte just consumed by readType to the
+// buffer. It must be called before anything else is read.
 func (r *CBORReader) pushbackType(pushback byte) {
-	r.pushback = pushback
-	r.pushed = true
+	r.pos--
+	if r.recording > 0 {
+		r.rec = r.rec[:len(r.rec)-1]
+	}
+}
+
+// readN consumes and returns the next n bytes of the input. The returned
+// slice is only valid until the next read.
+func (r *CBORReader) readN(n int) ([]byte, error) {
+	if err := r.checkBytes(uint64(n)); err != nil {
+		return nil, err
+	}
+	if err := r.fill(n); err != nil {
+		return nil, r.errorAt(unexpected(err))
+	}
+
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	if r.recording > 0 {
+		r.rec = append(r.rec, b...)
+	}
+	return b, nil
+}
+
+// PeekType returns the type of the next data item from the CBOR reader,
+// without consuming it.
+func (r *CBORReader) PeekType() (CBORType, error) {
+	ct, err := r.readType()
+	if err != nil {
+		return TypeNone, err
+	}
+	r.pushbackType(ct)
+
+	return typeOf(ct), nil
+}
+
+// PeekHeader returns the type and the argument of the header of the next data
+// item from the CBOR reader, without consuming it. The argument is the value
+// of an integer, the number of a tag, the length of a string, array or map,
+// or the bits of a float. If the item is a string, array or map of
+// indefinite length, indef is true and the argument is zero.
+func (r *CBORReader) PeekHeader() (t CBORType, u uint64, indef bool, err error) {
+	ct, err := r.readType()
+	if err != nil {
+		return TypeNone, 0, false, err
+	}
+	r.pushbackType(ct)
+
+	t = typeOf(ct)
+	switch ai := ct & majorMask; {
+	case ai < 24:
+		return t, uint64(ai), false, nil
+	case ai <= 27:
+		// look at the argument in the buffer, after the initial byte
+		n := 1 << (ai - 24)
+		if err := r.fill(1 + n); err != nil {
+			return TypeNone, 0, false, r.errorAt(unexpected(err))
+		}
+		for _, b := range r.buf[r.pos+1 : r.pos+1+n] {
+			u = u<<8 | uint64(b)
+		}
+		return t, u, false, nil
+	case ai == 31 && t == TypeBreak:
+		return t, 0, false, nil
+	case ai == 31 && ct&majorSelect >= majorBytes && ct&majorSelect <= majorMap:
+		return t, 0, true, nil
+	default:
+		return TypeNone, 0, false, r.invalid()
+	}
+}
+
+// readLength reads the header of a byte string, text string, array or map of
+// major type mt. If the item has indefinite length, indef is true and the
+// item's contents are terminated by a break stop code.
+func (r *CBORReader) readLength(mt byte) (u uint64, indef bool, err error) {
+	ct, err := r.readType()
+	if err != nil {
+		return 0, false, err
+	}
+	if ct == mt|31 {
+		if r.strict {
+			r.pushbackType(ct)
+			return 0, false, r.canonicalError(r.offset(), "indefinite length")
+		}
+		return 0, true, nil
+	}
+
+	r.pushbackType(ct)
+	u, _, _, err = r.readBasicUnsigned(mt)
+	return u, false, err
+}
+
+// readBreak consumes the break stop code if it is the next byte in the
+// stream, and reports whether it did so.
+func (r *CBORReader) readBreak() (bool, error) {
+	ct, err := r.readType()
+	if err != nil {
+		return false, err
+	}
+	if ct == majorOther|31 {
+		return true, nil
+	}
+
+	r.pushbackType(ct)
+	return false, nil
 }
 
 func (r *CBORReader) readBasicUnsigned(mt byte) (uint64, byte, bool, error) {
 	// read the first byte to see how much int to read
 
 	// byte 0 is the CBOR type
+	start := r.offset()
 	ct, err := r.readType()
 	if err != nil {
 		return 0, 0, false, err
@@ -73,13 +551,17 @@ func (r *CBORReader) readBasicUnsigned(mt byte) (uint64, byte, bool, error) {
 		default:
 			// type mismatch, push back
 			r.pushbackType(ct)
-			return 0, ct, false, CBORTypeReadError
+			return 0, ct, false, r.typeError(ct, TypeUnsigned)
 		}
 	} else {
 		if ct&majorSelect != mt {
 			// type mismatch, push back
 			r.pushbackType(ct)
-			return 0, ct, false, CBORTypeReadError
+			expected := typeOf(mt)
+			if mt == majorOther {
+				expected = TypeFloat
+			}
+			return 0, ct, false, r.typeError(ct, expected)
 		}
 	}
 
@@ -91,58 +573,75 @@ func (r *CBORReader) readBasicUnsigned(mt byte) (uint64, byte, bool, error) {
 		u = uint64(ct & majorMask)
 
 	case ct&majorMask == 24:
-		b := make([]byte, 1)
-		n, err := r.in.Read(b)
-		if n != len(b) {
-			return 0, 0, false, ShortReadError
-		} else if err != nil {
+		b, err := r.readN(1)
+		if err != nil {
 			return 0, 0, false, err
 		}
 		u = uint64(b[0])
 
 	case ct&majorMask == 25:
-		b := make([]byte, 2)
-		n, err := r.in.Read(b)
-		if n != len(b) {
-			return 0, 0, false, ShortReadError
-		} else if err != nil {
+		b, err := r.readN(2)
+		if err != nil {
 			return 0, 0, false, err
 		}
 		u = uint64(binary.BigEndian.Uint16(b))
 
 	case ct&majorMask == 26:
-		b := make([]byte, 4)
-		n, err := r.in.Read(b)
-		if n != len(b) {
-			return 0, 0, false, ShortReadError
-		} else if err != nil {
+		b, err := r.readN(4)
+		if err != nil {
 			return 0, 0, false, err
 		}
 		u = uint64(binary.BigEndian.Uint32(b))
 
 	case ct&majorMask == 27:
-		b := make([]byte, 8)
-		n, err := r.in.Read(b)
-		if n != len(b) {
-			return 0, 0, false, ShortReadError
-		} else if err != nil {
+		b, err := r.readN(8)
+		if err != nil {
 			return 0, 0, false, err
 		}
-		u = uint64(binary.BigEndian.Uint64(b))
+		u = binary.BigEndian.Uint64(b)
 
 	default:
-		return 0, 0, false, InvalidCBORError
+		r.pushbackType(ct)
+		return 0, 0, false, r.invalid()
+	}
+
+	// the arguments of floats and simple values are not integers
+	if r.strict && mt != majorOther && !minimalHeader(ct, u) {
+		return 0, 0, false, r.canonicalError(start, "non-minimal header")
 	}
 
 	return u, ct, neg, nil
 }
 
+// minimalHeader reports whether the initial byte ct is the shortest that can
+// carry the argument u.
+func minimalHeader(ct byte, u uint64) bool {
+	switch ct & majorMask {
+	case 24:
+		return u >= 24
+	case 25:
+		return u > math.MaxUint8
+	case 26:
+		return u > math.MaxUint16
+	case 27:
+		return u > math.MaxUint32
+	default:
+		return true
+	}
+}
+
 func (r *CBORReader) ReadInt() (int, error) {
 	var i int
-	u, _, neg, err := r.readBasicUnsigned(majorUnsigned)
+	start := r.offset()
+	u, ct, neg, err := r.readBasicUnsigned(majorUnsigned)
 	if err != nil {
 		return 0, err
 	}
+	if u > math.MaxInt {
+		e := r.errorAt(OverflowReadError)
+		e.Offset, e.Type = start, typeOf(ct)
+		return 0, e
+	}
 
 	// negate if necessary and return
 	if neg {
@@ -154,12 +653,38 @@ func (r *CBORReader) ReadInt() (int, error) {
 	return i, nil
 }
 
+// ReadUint reads an unsigned integer from the CBOR reader, covering the whole
+// range of uint64. Negative integers cannot be read.
 func (r *CBORReader) ReadUint() (uint64, error) {
-	if u, _, _, err := r.readBasicUnsigned(majorUnsigned); err != nil {
+	start := r.offset()
+	u, ct, neg, err := r.readBasicUnsigned(majorUnsigned)
+	if err != nil {
 		return 0, err
-	} else {
-		return u, nil
 	}
+	if neg {
+		e := r.errorAt(OverflowReadError)
+		e.Offset, e.Type = start, typeOf(ct)
+		return 0, e
+	}
+	return u, nil
+}
+
+// ReadBool reads a boolean value from the CBOR reader.
+func (r *CBORReader) ReadBool() (bool, error) {
+	ct, err := r.readType()
+	if err != nil {
+		return false, err
+	}
+
+	switch ct {
+	case 0xf4:
+		return false, nil
+	case 0xf5:
+		return true, nil
+	}
+
+	r.pushbackType(ct)
+	return false, r.typeError(ct, TypeBool)
 }
 
 func (r *CBORReader) ReadTag() (CBORTag, error) {
@@ -168,10 +693,12 @@ func (r *CBORReader) ReadTag() (CBORTag, error) {
 		return 0, err
 	}
 
+	r.tagged = true
 	return CBORTag(u), nil
 }
 
 func (r *CBORReader) ReadFloat() (float64, error) {
+	start := r.offset()
 	u, ct, _, err := r.readBasicUnsigned(majorOther)
 	if err != nil {
 		return 0, err
@@ -180,8 +707,8 @@ func (r *CBORReader) ReadFloat() (float64, error) {
 	var f float64
 	switch ct {
 	case majorOther | 25:
-		// FIXME: float16 is not supported right now.
-		return 0, UnsupportedTypeReadError
+		// 16 bit float.
+		f = float16ToFloat64(uint16(u))
 	case majorOther | 26:
 		// 32 bit float.
 		f = float64(math.Float32frombits(uint32(u)))
@@ -190,189 +717,568 @@ func (r *CBORReader) ReadFloat() (float64, error) {
 		f = math.Float64frombits(u)
 	default:
 		r.pushbackType(ct)
-		return 0, CBORTypeReadError
+		return 0, r.typeError(ct, TypeFloat)
+	}
+
+	if r.strict {
+		if math.IsNaN(f) && (ct != majorOther|25 || u != 0x7e00) {
+			return 0, r.canonicalError(start, "non-canonical NaN")
+		}
+		if shortestFloat(f)[0] != ct {
+			return 0, r.canonicalError(start, "float not in shortest form")
+		}
 	}
 
 	return f, nil
 }
 
-func (r *CBORReader) ReadBytes() ([]byte, error) {
-	// read length
-	u, _, _, err := r.readBasicUnsigned(majorBytes)
-	if err != nil {
-		return nil, err
-	}
+// float16ToFloat64 converts an IEEE 754 half-precision float to a float64.
+func float16ToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := uint64(h & 0x3ff)
 
-	// read u bytes and return them
-	b := make([]byte, u)
-	n, err := r.in.Read(b)
-	if n != 1 {
-		return nil, ShortReadError
-	} else if err != nil {
-		return nil, err
+	var f float64
+	switch exp {
+	case 0:
+		// zero or subnormal
+		f = math.Ldexp(float64(mant), -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(float64(mant|0x400), exp-25)
 	}
 
-	return b, nil
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
 }
 
-func (r *CBORReader) ReadString() (string, error) {
-	// read length
-	u, _, _, err := r.readBasicUnsigned(majorString)
+// readBasicBytes reads a byte string or text string of major type mt. An
+// indefinite-length string is returned as the concatenation of its chunks.
+func (r *CBORReader) readBasicBytes(mt byte) ([]byte, error) {
+	var out []byte
+	err := r.readChunks(mt, func(u uint64) error {
+		b, err := r.readTextChunk(mt, u)
+		if out == nil {
+			out = b
+		} else {
+			out = append(out, b...)
+		}
+		return err
+	})
 	if err != nil {
-		return "", err
-	}
-
-	// empty string special case.
-	if u == 0 {
-		return "", nil
+		return nil, err
 	}
-
-	// read u bytes and return them as a string
-	b := make([]byte, u)
-	n, err := r.in.Read(b)
-	if uint64(n) < u {
-		return "", ShortReadError
-	} else if err != nil {
-		return "", err
+	if out == nil {
+		out = make([]byte, 0)
 	}
 
-	return string(b), nil
+	return out, nil
 }
 
-func (r *CBORReader) ReadArray() ([]interface{}, error) {
-	// read length
-	u, _, _, err := r.readBasicUnsigned(majorArray)
+// readChunks reads the header of a byte string or text string of major type
+// mt, and calls chunk to consume the content of each of its chunks, of length
+// u. A definite-length string has a single chunk.
+func (r *CBORReader) readChunks(mt byte, chunk func(u uint64) error) error {
+	start := r.offset()
+	u, indef, err := r.readLength(mt)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	arraylen := int(u)
-
-	// create an output value
-	out := make([]interface{}, arraylen)
-
-	// now read that many values
-	for i := 0; i < arraylen; i++ {
-		v, err := r.Read()
-		if err != nil {
-			return nil, err
+	if !indef {
+		if u > uint64(r.maxStringLen) {
+			return r.limitError(start, "MaxStringLen", r.maxStringLen)
 		}
-		out[i] = v
+		return chunk(u)
 	}
 
-	return out, nil
-}
-
-func (r *CBORReader) ReadStringArray() ([]string, error) {
-	// read length
-	u, _, _, err := r.readBasicUnsigned(majorArray)
-	if err != nil {
-		return nil, err
+	// each chunk must be a definite-length string of the same major type
+	if err := r.enter(); err != nil {
+		return err
 	}
+	defer r.leave()
 
-	arraylen := int(u)
+	var total uint64
+	for {
+		brk, err := r.readBreak()
+		if err != nil {
+			return err
+		}
+		if brk {
+			return nil
+		}
 
-	// create an output value
-	out := make([]string, arraylen)
+		ct, err := r.readType()
+		if err != nil {
+			return err
+		}
+		r.pushbackType(ct)
+		if ct&majorSelect != mt || ct&majorMask == 31 {
+			return r.invalid()
+		}
 
-	// now read that many values
-	for i := 0; i < arraylen; i++ {
-		v, err := r.ReadString()
+		u, _, _, err := r.readBasicUnsigned(mt)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if u > uint64(r.maxStringLen)-total {
+			return r.limitError(start, "MaxStringLen", r.maxStringLen)
+		}
+		if err := chunk(u); err != nil {
+			return err
 		}
-		out[i] = v
+		total += u
 	}
-
-	return out, nil
 }
 
-func (r *CBORReader) ReadIntArray() ([]int, error) {
-	// read length
-	u, _, _, err := r.readBasicUnsigned(majorArray)
-	if err != nil {
-		return nil, err
+// readTextChunk reads u bytes of the content of a string of major type mt,
+// checking that each chunk of a text string is valid UTF-8 on its own.
+func (r *CBORReader) readTextChunk(mt byte, u uint64) ([]byte, error) {
+	start := r.offset()
+	b, err := r.readChunk(u)
+	if err != nil || mt != majorString || r.anyUTF8 {
+		return b, err
 	}
 
-	arraylen := int(u)
-
-	// create an output value
-	out := make([]int, arraylen)
+	if i := invalidUTF8(b); i >= 0 {
+		e := r.errorAt(&InvalidUTF8Error{Offset: i})
+		e.Offset = start + int64(i)
+		return nil, e
+	}
+	return b, nil
+}
 
-	// now read as many values as there should be
-	for i := 0; i < arraylen; i++ {
-		v, err := r.ReadInt()
+// readChunk reads u bytes of string content from the stream.
+func (r *CBORReader) readChunk(u uint64) ([]byte, error) {
+	if err := r.checkBytes(u); err != nil {
+		return nil, err
+	}
+	if u <= uint64(len(r.buf)) {
+		b, err := r.readN(int(u))
 		if err != nil {
 			return nil, err
 		}
-		out[i] = v
+		return append(make([]byte, 0, u), b...), nil
 	}
 
-	return out, nil
-}
-
-func (r *CBORReader) ReadStringMap() (map[string]interface{}, error) {
-	// read length
-	u, _, _, err := r.readBasicUnsigned(majorMap)
-	if err != nil {
-		return nil, err
+	// Grow the result as the content arrives rather than trusting the
+	// declared length, which may be far larger than the input.
+	b := make([]byte, 0, len(r.buf))
+	for uint64(len(b)) < u {
+		if err := r.fill(1); err != nil {
+			return nil, r.errorAt(unexpected(err))
+		}
+		n := r.end - r.pos
+		if rest := u - uint64(len(b)); uint64(n) > rest {
+			n = int(rest)
+		}
+		b = append(b, r.buf[r.pos:r.pos+n]...)
+		if r.recording > 0 {
+			r.rec = append(r.rec, r.buf[r.pos:r.pos+n]...)
+		}
+		r.pos += n
 	}
 
-	maplen := int(u)
+	return b, nil
+}
 
-	// create an output value
-	out := make(map[string]interface{})
+// skipChunk consumes u bytes of string content from the stream without
+// keeping them.
+func (r *CBORReader) skipChunk(u uint64) error {
+	if err := r.checkBytes(u); err != nil {
+		return err
+	}
 
-	// now read as many key/value pairs as there should be
-	for i := 0; i < maplen; i++ {
-		var ks string
-		k, err := r.Read()
+	for u > 0 {
+		if err := r.fill(1); err != nil {
+			return r.errorAt(unexpected(err))
+		}
+		n := r.end - r.pos
+		if uint64(n) > u {
+			n = int(u)
+		}
+		if r.recording > 0 {
+			r.rec = append(r.rec, r.buf[r.pos:r.pos+n]...)
+		}
+		r.pos += n
+		u -= uint64(n)
+	}
+
+	return nil
+}
+
+func (r *CBORReader) ReadBytes() ([]byte, error) {
+	return r.readBasicBytes(majorBytes)
+}
+
+func (r *CBORReader) ReadString() (string, error) {
+	b, err := r.readBasicBytes(majorString)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// container tracks the elements of an array or map whose header was read by
+// readContainer. For maps, each key-value pair counts as one element.
+type container struct {
+	start int64 // input offset of the header
+	n     int   // elements remaining, or -1 for indefinite length
+	count int64 // elements read so far
+	max   int64 // limit on the number of elements
+	limit string
+
+	lastKey []byte // encoding of the last key read from a map in strict mode
+}
+
+// readContainer reads the header of an array or map of major type mt. The
+// elements are then stepped through with hasMore.
+func (r *CBORReader) readContainer(mt byte) (container, error) {
+	c := container{start: r.offset(), max: r.maxArrayLen, limit: "MaxArrayLen"}
+	if mt == majorMap {
+		c.max, c.limit = r.maxMapLen, "MaxMapLen"
+	}
+
+	u, indef, err := r.readLength(mt)
+	if err != nil {
+		return c, err
+	}
+	if indef {
+		c.n = -1
+		return c, nil
+	}
+	if u > uint64(c.max) {
+		return c, r.limitError(c.start, c.limit, c.max)
+	}
+	if u > math.MaxInt32 {
+		return c, r.invalid()
+	}
+
+	c.n = int(u)
+	return c, nil
+}
+
+// sizeHint returns the capacity to preallocate for the elements of c.
+func (c *container) sizeHint() int {
+	if c.n < 0 {
+		return 0
+	}
+	if c.n > maxPrealloc {
+		return maxPrealloc
+	}
+	return c.n
+}
+
+// enter and leave bracket the reading of the contents of a container or tag.
+// enter fails if this would exceed MaxDepth.
+func (r *CBORReader) enter() error {
+	if int64(r.depth) >= r.maxDepth {
+		return r.limitError(r.offset(), "MaxDepth", r.maxDepth)
+	}
+	r.depth++
+	return nil
+}
+
+func (r *CBORReader) leave() {
+	r.depth--
+}
+
+// hasMore reports whether another element follows in the container c, and
+// counts it off. For indefinite-length containers it consumes the terminating
+// break.
+func (r *CBORReader) hasMore(c *container) (bool, error) {
+	if c.n >= 0 {
+		if c.n == 0 {
+			return false, nil
+		}
+		c.n--
+		c.count++
+		return true, nil
+	}
+
+	brk, err := r.readBreak()
+	if err != nil || brk {
+		return false, err
+	}
+	if c.count >= c.max {
+		return false, r.limitError(c.start, c.limit, c.max)
+	}
+	c.count++
+	return true, nil
+}
+
+func (r *CBORReader) ReadArray() ([]interface{}, error) {
+	// read length
+	n, err := r.readContainer(majorArray)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.enter(); err != nil {
+		return nil, err
+	}
+	defer r.leave()
+
+	// create an output value
+	out := make([]interface{}, 0, n.sizeHint())
+
+	// now read as many values as there are
+	for {
+		more, err := r.hasMore(&n)
 		if err != nil {
 			return nil, err
 		}
-		switch k.(type) {
-		case string:
-			ks = k.(string)
-		default:
-			ks = fmt.Sprintf("%v", k)
+		if !more {
+			break
 		}
 
 		v, err := r.Read()
 		if err != nil {
 			return nil, err
 		}
+		out = append(out, v)
+	}
 
-		out[ks] = v
+	return out, nil
+}
+
+func (r *CBORReader) ReadStringArray() ([]string, error) {
+	// read length
+	n, err := r.readContainer(majorArray)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.enter(); err != nil {
+		return nil, err
+	}
+	defer r.leave()
+
+	// create an output value
+	out := make([]string, 0, n.sizeHint())
+
+	// now read as many values as there are
+	for {
+		more, err := r.hasMore(&n)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+
+		v, err := r.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
 	}
 
 	return out, nil
 }
 
-func (r *CBORReader) ReadIntMap() (map[int]interface{}, error) {
+func (r *CBORReader) ReadIntArray() ([]int, error) {
 	// read length
-	u, _, _, err := r.readBasicUnsigned(majorArray)
+	n, err := r.readContainer(majorArray)
 	if err != nil {
 		return nil, err
 	}
+	if err := r.enter(); err != nil {
+		return nil, err
+	}
+	defer r.leave()
+
+	// create an output value
+	out := make([]int, 0, n.sizeHint())
 
-	maplen := int(u)
+	// now read as many values as there are
+	for {
+		more, err := r.hasMore(&n)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+
+		v, err := r.ReadInt()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+
+	return out, nil
+}
+
+func (r *CBORReader) ReadStringMap() (map[string]interface{}, error) {
+	// read length
+	n, err := r.readContainer(majorMap)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.enter(); err != nil {
+		return nil, err
+	}
+	defer r.leave()
 
 	// create an output value
-	out := make(map[int]interface{})
+	out := make(map[string]interface{}, n.sizeHint())
+
+	// now read as many key/value pairs as there are
+	for {
+		more, err := r.hasMore(&n)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
 
-	// now read as many key/value pairs as there should be
-	for i := 0; i < maplen; i++ {
-		k, err := r.ReadInt()
+		start := r.offset()
+		k, err := r.readMapKey(&n, r.Read)
 		if err != nil {
 			return nil, err
 		}
+		ks := mapKeyString(k)
+
+		keep := true
+		if _, dup := out[ks]; dup {
+			if keep, err = r.duplicateKey(start, k); err != nil {
+				return nil, err
+			}
+		}
+
+		if !keep {
+			// the value of a duplicate key being ignored is discarded
+			if err := r.Skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
 
 		v, err := r.Read()
 		if err != nil {
 			return nil, err
 		}
+		out[ks] = v
+	}
+
+	return out, nil
+}
+
+// readMapKey reads the next key of the map c with read. In strict mode, it
+// checks that the keys are unique and in the bytewise order of their
+// encodings.
+func (r *CBORReader) readMapKey(c *container, read func() (interface{}, error)) (interface{}, error) {
+	if !r.strict {
+		return read()
+	}
+
+	start := r.offset()
+	mark := r.startRecord()
+	k, err := read()
+	key := r.stopRecord(mark)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.lastKey != nil {
+		switch bytes.Compare(key, c.lastKey) {
+		case 0:
+			return nil, r.canonicalError(start, "duplicate map key")
+		case -1:
+			return nil, r.canonicalError(start, "map keys not sorted")
+		}
+	}
+	c.lastKey = append(c.lastKey[:0], key...)
+	return k, nil
+}
 
-		out[k] = v
+// duplicateKey applies the duplicate key policy to the key k at offset start,
+// which has been seen before in the same map. It reports whether the value for
+// this occurrence of the key should be kept.
+func (r *CBORReader) duplicateKey(start int64, k interface{}) (bool, error) {
+	switch r.dupKeys {
+	case DupKeyKeepFirst:
+		return false, nil
+	case DupKeyReject:
+		e := r.errorAt(&DuplicateKeyError{Key: k})
+		e.Offset = start
+		return false, e
+	default:
+		return true, nil
+	}
+}
+
+// mapKeyString coerces a map key read by Read to a string.
+func mapKeyString(k interface{}) string {
+	switch k.(type) {
+	case string:
+		return k.(string)
+	default:
+		return fmt.Sprintf("%v", k)
+	}
+}
+
+func (r *CBORReader) ReadIntMap() (map[int]interface{}, error) {
+	// read length
+	n, err := r.readContainer(majorMap)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.enter(); err != nil {
+		return nil, err
+	}
+	defer r.leave()
+
+	// create an output value
+	out := make(map[int]interface{}, n.sizeHint())
+
+	// now read as many key/value pairs as there are
+	for {
+		more, err := r.hasMore(&n)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+
+		start := r.offset()
+		k, err := r.readMapKey(&n, func() (interface{}, error) {
+			return r.ReadInt()
+		})
+		if err != nil {
+			return nil, err
+		}
+		ki := k.(int)
+
+		keep := true
+		if _, dup := out[ki]; dup {
+			if keep, err = r.duplicateKey(start, ki); err != nil {
+				return nil, err
+			}
+		}
+
+		if !keep {
+			// the value of a duplicate key being ignored is discarded
+			if err := r.Skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		v, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+		out[ki] = v
 	}
 
 	return out, nil
@@ -381,55 +1287,52 @@ func (r *CBORReader) ReadIntMap() (map[int]interface{}, error) {
 func (r *CBORReader) ReadTime() (time.Time, error) {
 	// Case 1: the time is just a float, integer, or string.
 	// In this case we just treat it as if it were tagged.
-	ct, err := r.readType()
+	t, err := r.PeekType()
 	if err != nil {
 		return time.Unix(0, 0), err
 	}
-	switch ct & majorMask {
-	case majorOther:
-		if ct == majorOther|25 || ct == majorOther|26 || ct == majorOther|27 {
-			// Floating point timestamp.
-			r.pushbackType(ct)
-			f, err := r.ReadFloat()
-			if err != nil {
-				return time.Unix(0, 0), err
-			}
-			whole, frac := math.Modf(f)
-			secs := int64(whole)
-			ns := int64(frac * 10e9)
-			return time.Unix(secs, ns), nil
-		} else {
-			return time.Unix(0, 0), fmt.Errorf("got malformed majorOther type for timestamp: %x", ct)
+	switch t {
+	case TypeFloat:
+		// Floating point timestamp.
+		f, err := r.ReadFloat()
+		if err != nil {
+			return time.Unix(0, 0), err
 		}
-	case majorNegative:
-		fallthrough
-	case majorUnsigned:
-		r.pushbackType(ct)
+		whole, frac := math.Modf(f)
+		secs := int64(whole)
+		ns := int64(frac * 1e9)
+		return time.Unix(secs, ns), nil
+	case TypeUnsigned, TypeNegative:
 		i, err := r.ReadInt()
 		if err != nil {
 			return time.Unix(0, 0), err
 		}
 		return time.Unix(int64(i), 0), nil
-	case majorString:
-		r.pushbackType(ct)
+	case TypeString:
 		s, err := r.ReadString()
 		if err != nil {
 			return time.Unix(0, 0), err
 		}
 		if t, err := time.Parse(time.RFC3339, s); err != nil {
-			return time.Unix(0, 0), err
+			return time.Unix(0, 0), r.errorAt(err)
 		} else {
 			return t, nil
 		}
-	case majorTag:
-		break // Fall through to the tag logic below.
+	case TypeTag:
+		// Case 2: the time is tagged.
+		tag, err := r.ReadTag()
+		if err != nil {
+			return time.Unix(0, 0), err
+		}
+		return r.readTaggedTime(tag)
 	default:
-		return time.Unix(0, 0), fmt.Errorf("Unsupported tag for parsing time: %v", ct&majorMask)
-	}
-	tag, err := r.ReadTag()
-	if err != nil {
-		return time.Unix(0, 0), err
+		return time.Unix(0, 0), r.wrongType(t, TypeNone)
 	}
+}
+
+// readTaggedTime reads the content of a date/time tag whose number has
+// already been read.
+func (r *CBORReader) readTaggedTime(tag CBORTag) (time.Time, error) {
 	// Two tags are allowed: 0 for RFC3339 time, 1 for POSIX epoch time.
 	switch tag {
 	case TagDateTimeString:
@@ -438,7 +1341,7 @@ func (r *CBORReader) ReadTime() (time.Time, error) {
 			return time.Unix(0, 0), err
 		}
 		if t, err := time.Parse(time.RFC3339, s); err != nil {
-			return time.Unix(0, 0), err
+			return time.Unix(0, 0), r.errorAt(err)
 		} else {
 			return t, nil
 		}
@@ -449,7 +1352,7 @@ func (r *CBORReader) ReadTime() (time.Time, error) {
 		if err != nil {
 			return time.Unix(0, 0), err
 		}
-		switch ct & majorMask {
+		switch ct & majorSelect {
 		case majorNegative:
 			fallthrough
 		case majorUnsigned:
@@ -468,16 +1371,20 @@ func (r *CBORReader) ReadTime() (time.Time, error) {
 				}
 				whole, frac := math.Modf(f)
 				secs := int64(whole)
-				ns := int64(frac * 10e9)
+				ns := int64(frac * 1e9)
 				return time.Unix(secs, ns), nil
 			} else {
-				return time.Unix(0, 0), fmt.Errorf("got malformed majorOther type for timestamp: %x", ct)
+				r.pushbackType(ct)
+				return time.Unix(0, 0), r.typeError(ct, TypeFloat)
 			}
 		default:
-			return time.Unix(0, 0), fmt.Errorf("Timestamp not understood.")
+			r.pushbackType(ct)
+			return time.Unix(0, 0), r.typeError(ct, TypeNone)
 		}
 	default:
-		return time.Unix(0, 0), fmt.Errorf("Unrecognized time tag")
+		e := r.errorAt(CBORTypeReadError)
+		e.Type = TypeTag
+		return time.Unix(0, 0), e
 	}
 }
 
@@ -485,13 +1392,18 @@ func (r *CBORReader) ReadTime() (time.Time, error) {
 // returns a single interface{} of one of the following types, depending on the
 // major type of the next CBOR object in the stream:
 //
-// - Unsigned (major 0): uint
-// - Negative (major 1): int
+// - Unsigned (major 0): uint64
+// - Negative (major 1): int, or *big.Int if the value does not fit an int
 // - Byte array (major 2): []byte
 // - String (major 3): string
 // - Array (major 4): []interface{}
 // - Map (major 5): map[string]interface{}, with keys coerced to strings via Sprintf("%v").
-// - Tag (major 6): CBORTag type
+// - Tag (major 6) 0 or 1: time.Time
+// - Tag (major 6) 2 or 3: *big.Int
+// - Tag (major 6) 4: Decimal
+// - Tag (major 6) 5: BigFloat
+// - Tag (major 6) 30: *big.Rat
+// - Tag (major 6) otherwise: Tag, holding the tag number and its content
 // - Other (major 7) float: float64
 // - Other (major 7) true or false: bool
 // - Other (major 7) nil: nil
@@ -509,7 +1421,15 @@ func (r *CBORReader) Read() (interface{}, error) {
 		return r.ReadUint()
 	case majorNegative:
 		r.pushbackType(ct)
-		return r.ReadInt()
+		u, _, _, err := r.readBasicUnsigned(majorNegative)
+		if err != nil {
+			return nil, err
+		}
+		if u > math.MaxInt {
+			// too small for an int
+			return new(big.Int).Not(new(big.Int).SetUint64(u)), nil
+		}
+		return -1 - int(u), nil
 	case majorBytes:
 		r.pushbackType(ct)
 		return r.ReadBytes()
@@ -524,7 +1444,7 @@ func (r *CBORReader) Read() (interface{}, error) {
 		return r.ReadStringMap()
 	case majorTag:
 		r.pushbackType(ct)
-		return r.ReadTag()
+		return r.readTagged()
 	case majorOther:
 		switch {
 		case ct == majorOther|25 || ct == majorOther|26 || ct == majorOther|27:
@@ -540,25 +1460,172 @@ func (r *CBORReader) Read() (interface{}, error) {
 	}
 
 	// if we're here, pretend this isn't CBOR
-	return nil, InvalidCBORError
+	r.pushbackType(ct)
+	return nil, r.invalid()
+}
+
+// Skip steps over the next data item from the CBOR reader, including the
+// contents of any containers and tags, without building any Go values. It
+// checks that the item is well-formed and within the reader's limits. In
+// strict mode, it also checks the canonical encoding of the item.
+func (r *CBORReader) Skip() error {
+	ct, err := r.readType()
+	if err != nil {
+		return err
+	}
+	r.pushbackType(ct)
+
+	switch mt := ct & majorSelect; mt {
+	case majorUnsigned, majorNegative:
+		_, _, _, err := r.readBasicUnsigned(majorUnsigned)
+		return err
+	case majorBytes, majorString:
+		return r.readChunks(mt, r.skipChunk)
+	case majorArray, majorMap:
+		return r.skipContainer(mt)
+	case majorTag:
+		tag, err := r.ReadTag()
+		if err != nil {
+			return err
+		}
+		if err := r.enter(); err != nil {
+			return err
+		}
+		defer r.leave()
+		if r.strict && (tag == TagPosBignum || tag == TagNegBignum) {
+			_, err := r.readBignum(tag)
+			return err
+		}
+		return r.Skip()
+	case majorOther:
+		switch {
+		case ct == majorOther|25 || ct == majorOther|26 || ct == majorOther|27:
+			_, err := r.ReadFloat()
+			return err
+		case ct <= majorOther|23:
+			// false, true, null, undefined and other simple values
+			_, err := r.readType()
+			return err
+		case ct == majorOther|24:
+			// simple values below 32 must use the one-byte form
+			start := r.offset()
+			if _, err := r.readType(); err != nil {
+				return err
+			}
+			b, err := r.readN(1)
+			if err != nil {
+				return err
+			}
+			if b[0] < 32 {
+				e := r.errorAt(InvalidCBORError)
+				e.Offset = start
+				return e
+			}
+			return nil
+		}
+	}
+
+	// reserved additional information, or a break outside a container
+	return r.invalid()
+}
+
+// skipContainer steps over an array or map of major type mt.
+func (r *CBORReader) skipContainer(mt byte) error {
+	n, err := r.readContainer(mt)
+	if err != nil {
+		return err
+	}
+	if err := r.enter(); err != nil {
+		return err
+	}
+	defer r.leave()
+
+	for {
+		more, err := r.hasMore(&n)
+		if err != nil || !more {
+			return err
+		}
+
+		if mt == majorMap {
+			_, err := r.readMapKey(&n, func() (interface{}, error) {
+				return nil, r.Skip()
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if err := r.Skip(); err != nil {
+			return err
+		}
+	}
+}
+
+// ReadRaw reads the next data item from the CBOR reader, and returns a copy
+// of its encoding, including the contents of any containers and tags.
+func (r *CBORReader) ReadRaw() (RawMessage, error) {
+	mark := r.startRecord()
+	err := r.Skip()
+	b := r.stopRecord(mark)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(RawMessage(nil), b...), nil
+}
+
+// readTagged reads a tag and its content, decoding the tags it recognises.
+func (r *CBORReader) readTagged() (interface{}, error) {
+	tag, err := r.ReadTag()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.enter(); err != nil {
+		return nil, err
+	}
+	defer r.leave()
+
+	switch tag {
+	case TagDateTimeString, TagDateTimeEpoch:
+		return r.readTaggedTime(tag)
+	case TagPosBignum, TagNegBignum:
+		return r.readBignum(tag)
+	case TagDecimal:
+		exp, mant, err := r.readFraction()
+		return Decimal{Mantissa: mant, Exponent: exp}, err
+	case TagBigFloat:
+		exp, mant, err := r.readFraction()
+		return BigFloat{Mantissa: mant, Exponent: exp}, err
+	case TagRational:
+		return r.readRational()
+	default:
+		v, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+		return Tag{Number: tag, Content: v}, nil
+	}
 }
 
 // Unmarshal attempts to read the next value from the CBOR reader and store it
 // in the value pointed to by v, according to v's type. Returns
 // CBORTypeReadError if the type does not match or cannot be made to match.
-// Values are handled as in Marshal().
+// Values are handled as in Marshal(). Errors reading the input are returned
+// as *DecodeError, with the path of the Go value being filled.
 func (r *CBORReader) Unmarshal(x interface{}) error {
 
 	pv := reflect.ValueOf(x)
 
 	// make sure we have a pointer to a thing
+	if !pv.IsValid() {
+		return errors.New("cannot unmarshal CBOR to nil")
+	}
 	if pv.Kind() != reflect.Ptr || pv.IsNil() {
 		return fmt.Errorf("cannot unmarshal CBOR to non-pointer type %v", pv.Type())
 	}
 
 	// if the type implements unmarshaler, just do that
-	if pv.Type().Elem().Implements(reflect.TypeOf((*CBORMarshaler)(nil)).Elem()) {
-		return pv.Elem().Interface().(CBORUnmarshaler).UnmarshalCBOR(r)
+	if pv.Type().Implements(unmarshalerType) {
+		return pv.Interface().(CBORUnmarshaler).UnmarshalCBOR(r)
 	}
 
 	// make sure the thing is settable
@@ -566,79 +1633,432 @@ func (r *CBORReader) Unmarshal(x interface{}) error {
 		return fmt.Errorf("cannot unmarshal CBOR to type %v: not settable by reflection", pv.Type())
 	}
 
-	// otherwise, read value based on value's element kind
-	switch pv.Elem().Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		i, err := r.ReadInt()
+	// start the path at the outermost value, unless we are being called
+	// from the UnmarshalCBOR method of a value further down
+	if len(r.path) == 0 {
+		r.path = append(r.path, pathElem{kind: pathRoot, t: pv.Type().Elem()})
+		defer func() { r.path = r.path[:0] }()
+	}
+
+	return r.unmarshalValue(pv.Elem())
+}
+
+var unmarshalerType = reflect.TypeOf((*CBORUnmarshaler)(nil)).Elem()
+
+// typeName returns the name of t for use in a path.
+func typeName(t reflect.Type) string {
+	if t.Name() != "" {
+		return t.Name()
+	}
+	return t.String()
+}
+
+// pathElem is one step in the path of the Go value being filled by
+// Unmarshal. Steps are only formatted when an error is reported, so decoding
+// does not pay for them.
+type pathElem struct {
+	kind  pathKind
+	t     reflect.Type  // the outermost type, or the struct of a field
+	index int           // the index of a field or element
+	key   reflect.Value // the key of a map entry
+}
+
+type pathKind int
+
+const (
+	pathRoot pathKind = iota
+	pathField
+	pathIndex
+	pathKey
+)
+
+// pathString formats the path of the Go value being filled by Unmarshal,
+// such as "Zone.Assertions[3].Content".
+func (r *CBORReader) pathString() string {
+	var sb strings.Builder
+	for _, e := range r.path {
+		switch e.kind {
+		case pathRoot:
+			sb.WriteString(typeName(e.t))
+		case pathField:
+			sb.WriteByte('.')
+			sb.WriteString(e.t.Field(e.index).Name)
+		case pathIndex:
+			sb.WriteByte('[')
+			sb.WriteString(strconv.Itoa(e.index))
+			sb.WriteByte(']')
+		case pathKey:
+			fmt.Fprintf(&sb, "[%v]", e.key.Interface())
+		}
+	}
+	return sb.String()
+}
+
+// unmarshalValue reads the next value from the CBOR reader into v, which must
+// be settable.
+func (r *CBORReader) unmarshalValue(v reflect.Value) error {
+	// if the type implements unmarshaler, just do that
+	if v.Addr().Type().Implements(unmarshalerType) {
+		return v.Addr().Interface().(CBORUnmarshaler).UnmarshalCBOR(r)
+	}
+
+	// null sets pointers, slices, maps and interfaces to nil
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		t, err := r.PeekType()
 		if err != nil {
 			return err
 		}
-		pv.Elem().SetInt(int64(i))
-		return nil
+		if t == TypeNull {
+			v.Set(reflect.Zero(v.Type()))
+			return r.Skip()
+		}
+	}
+
+	// otherwise, read value based on value's kind
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return r.readReflectedInt(v)
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		i, err := r.ReadInt()
+		return r.readReflectedUint(v)
+	case reflect.Float32, reflect.Float64:
+		start := r.offset()
+		f, err := r.ReadFloat()
 		if err != nil {
 			return err
 		}
-		pv.Elem().SetUint(uint64(i))
+		if v.OverflowFloat(f) {
+			return r.overflowError(start, TypeFloat, v.Type())
+		}
+		v.SetFloat(f)
 		return nil
 	case reflect.String:
 		s, err := r.ReadString()
 		if err != nil {
 			return err
 		}
-		pv.Elem().SetString(s)
+		v.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := r.ReadBool()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
 		return nil
 	case reflect.Slice:
-		switch pv.Elem().Type() {
-		case reflect.TypeOf([]string{}):
-			sl, err := r.ReadStringArray()
+		if v.Type() == reflect.TypeOf([]interface{}{}) {
+			sl, err := r.ReadArray()
 			if err != nil {
 				return err
 			}
-			pv.Elem().Set(reflect.ValueOf(sl))
+			v.Set(reflect.ValueOf(sl))
 			return nil
-		case reflect.TypeOf([]int{}):
-			sl, err := r.ReadIntArray()
+		}
+		return r.readReflectedSlice(v)
+	case reflect.Array:
+		return r.readReflectedSlice(v)
+	case reflect.Map:
+		return r.readReflectedMap(v)
+	case reflect.Ptr:
+		// allocate nil pointers, and read into what they point to
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return r.unmarshalValue(v.Elem())
+	case reflect.Struct:
+		// big integers may be encoded as plain integers or bignums
+		if v.Type() == reflect.TypeOf(big.Int{}) {
+			b, err := r.ReadBigInt()
 			if err != nil {
 				return err
 			}
-			pv.Elem().Set(reflect.ValueOf(sl))
+			v.Addr().Interface().(*big.Int).Set(b)
 			return nil
-		default:
-			sl, err := r.ReadArray()
+		}
+		if v.Type() == reflect.TypeOf(big.Rat{}) {
+			x, err := r.ReadRat()
 			if err != nil {
 				return err
 			}
-			pv.Elem().Set(reflect.ValueOf(sl))
+			v.Addr().Interface().(*big.Rat).Set(x)
 			return nil
 		}
-	case reflect.Array:
-		return fmt.Errorf("Cannot unmarshal objects of type %v from CBOR", pv.Type().Elem())
-	case reflect.Struct:
 		// treat times sepcially
-		if pv.Elem().Type() == reflect.TypeOf(time.Time{}) {
+		if v.Type() == reflect.TypeOf(time.Time{}) {
 			t, err := r.ReadTime()
 			if err != nil {
 				return err
 			}
-			pv.Elem().Set(reflect.ValueOf(t))
+			v.Set(reflect.ValueOf(t))
 			return nil
 		} else {
-			return r.readReflectedStruct(pv.Elem())
+			return r.readReflectedStruct(v)
 		}
-	case reflect.Bool:
-		b, err := r.Read()
+	default:
+		// store anything else as read, if the types allow it; interface{}
+		// destinations get exactly what Read returns
+		x, err := r.Read()
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if !reflect.TypeOf(x).AssignableTo(v.Type()) {
+			return r.unsupported(v.Type())
+		}
+		v.Set(reflect.ValueOf(x))
+		return nil
+	}
+}
+
+// readReflectedMap reads a map from the reader into the map v, allocating it
+// if it is nil. Entries are added to any already in v. Each key and value is
+// converted to the key and element types of v; integer keys must fit.
+func (r *CBORReader) readReflectedMap(v reflect.Value) error {
+	n, err := r.readContainer(majorMap)
+	if err != nil {
+		return err
+	}
+	if err := r.enter(); err != nil {
+		return err
+	}
+	defer r.leave()
+
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(v.Type(), n.sizeHint()))
+	}
+
+	// keys are only remembered if duplicates need handling; keys already in
+	// the map being merged into are not duplicates
+	var seen map[interface{}]bool
+	if r.dupKeys != DupKeyKeepLast {
+		seen = make(map[interface{}]bool)
+	}
+
+	// each key and value is read into the same temporaries, which
+	// SetMapIndex copies
+	k := reflect.New(v.Type().Key()).Elem()
+	e := reflect.New(v.Type().Elem()).Elem()
+	for {
+		more, err := r.hasMore(&n)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+
+		start := r.offset()
+		k.SetZero()
+		if err := r.readReflectedKey(&n, k); err != nil {
+			return err
+		}
+		// byte strings, arrays and maps read into interface{} keys, even
+		// within structs or arrays, can't be used; other keys always can
+		switch k.Kind() {
+		case reflect.Interface, reflect.Struct, reflect.Array:
+			if !k.Comparable() {
+				de := r.errorAt(fmt.Errorf("%w: cannot use %T as a key of %v", UnsupportedTypeReadError, k.Interface(), v.Type()))
+				de.Offset = start
+				return de
+			}
+		}
+
+		keep := true
+		if seen != nil {
+			if seen[k.Interface()] {
+				if keep, err = r.duplicateKey(start, k.Interface()); err != nil {
+					return err
+				}
+			}
+			seen[k.Interface()] = true
+		}
+		if !keep {
+			if err := r.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		e.SetZero()
+		r.path = append(r.path, pathElem{kind: pathKey, key: k})
+		err = r.unmarshalValue(e)
+		r.path = r.path[:len(r.path)-1]
 		if err != nil {
 			return err
 		}
-		switch b.(type) {
-		case bool:
-			pv.Elem().Set(reflect.ValueOf(b))
+		v.SetMapIndex(k, e)
+	}
+}
+
+// readReflectedKey reads the next key of the map c into k. Strings are
+// coerced from other keys as ReadStringMap does; anything else is
+// unmarshaled, so integers are checked to fit in the type of k.
+func (r *CBORReader) readReflectedKey(c *container, k reflect.Value) error {
+	_, err := r.readMapKey(c, func() (interface{}, error) {
+		if k.Kind() != reflect.String {
+			return nil, r.unmarshalValue(k)
 		}
+		x, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+		k.SetString(mapKeyString(x))
+		return nil, nil
+	})
+	return err
+}
+
+// readReflectedInt reads an integer into v, which is of a signed integer kind,
+// failing if it does not fit.
+func (r *CBORReader) readReflectedInt(v reflect.Value) error {
+	start := r.offset()
+	u, ct, neg, err := r.readBasicUnsigned(majorUnsigned)
+	if err != nil {
+		return err
+	}
+	i := int64(u)
+	if neg {
+		i = -1 - i
+	}
+	if u > math.MaxInt64 || v.OverflowInt(i) {
+		return r.overflowError(start, typeOf(ct), v.Type())
 	}
+	v.SetInt(i)
+	return nil
+}
 
-	return fmt.Errorf("Cannot unmarshal objects of type %v from CBOR", pv.Type().Elem())
+// readReflectedUint reads an integer into v, which is of an unsigned integer
+// kind, failing if it is negative or does not fit.
+func (r *CBORReader) readReflectedUint(v reflect.Value) error {
+	start := r.offset()
+	u, ct, neg, err := r.readBasicUnsigned(majorUnsigned)
+	if err != nil {
+		return err
+	}
+	if neg || v.OverflowUint(u) {
+		return r.overflowError(start, typeOf(ct), v.Type())
+	}
+	v.SetUint(u)
+	return nil
+}
+
+// overflowError returns an error for a number of type ct at start which does
+// not fit in a value of type t.
+func (r *CBORReader) overflowError(start int64, ct CBORType, t reflect.Type) *DecodeError {
+	e := r.errorAt(fmt.Errorf("%w: does not fit in %v", OverflowReadError, t))
+	e.Offset, e.Type = start, ct
+	return e
+}
+
+// readReflectedSlice reads an array from the reader into the slice or array
+// v, one element at a time. An array must be read from an array of the same
+// length. Byte slices and arrays may also be read from byte strings.
+func (r *CBORReader) readReflectedSlice(v reflect.Value) error {
+	start := r.offset()
+	t, err := r.PeekType()
+	if err != nil {
+		return err
+	}
+	if t == TypeBytes && v.Type().Elem().Kind() == reflect.Uint8 {
+		b, err := r.ReadBytes()
+		if err != nil {
+			return err
+		}
+		if v.Kind() == reflect.Slice {
+			v.SetBytes(b)
+		} else if len(b) != v.Len() {
+			return r.lengthError(start, t, len(b), v.Len())
+		} else {
+			// set each element, as named byte types can't be copied from a []byte
+			for i, c := range b {
+				v.Index(i).SetUint(uint64(c))
+			}
+		}
+		return nil
+	}
+
+	n, err := r.readContainer(majorArray)
+	if err != nil {
+		return err
+	}
+	isArray := v.Kind() == reflect.Array
+	if isArray && n.n >= 0 && n.n != v.Len() {
+		return r.lengthError(start, TypeArray, n.n, v.Len())
+	}
+	if err := r.enter(); err != nil {
+		return err
+	}
+	defer r.leave()
+
+	// arrays are filled in place, slices are built up; a settable slice can
+	// be grown without the allocation reflect.Append makes for each element
+	out := v
+	if !isArray {
+		out = reflect.New(v.Type()).Elem()
+		out.Set(reflect.MakeSlice(v.Type(), 0, n.sizeHint()))
+	}
+	i := 0
+	for ; ; i++ {
+		more, err := r.hasMore(&n)
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+
+		if !isArray {
+			out.Grow(1)
+			out.SetLen(i + 1)
+		} else if i == v.Len() {
+			return r.lengthError(start, TypeArray, i+1, v.Len())
+		}
+		r.path = append(r.path, pathElem{kind: pathIndex, index: i})
+		err = r.unmarshalValue(out.Index(i))
+		r.path = r.path[:len(r.path)-1]
+		if err != nil {
+			return err
+		}
+	}
+
+	if isArray {
+		if i != v.Len() {
+			return r.lengthError(start, TypeArray, i, v.Len())
+		}
+		return nil
+	}
+	v.Set(out)
+	return nil
+}
+
+// lengthError reports that the string or array of type t at offset start has
+// n elements, which cannot be stored in a Go array of length want. For an
+// indefinite-length array with too many elements, n is a lower bound.
+func (r *CBORReader) lengthError(start int64, t CBORType, n, want int) error {
+	e := r.errorAt(fmt.Errorf("%w: length %d, expected %d", CBORTypeReadError, n, want))
+	e.Offset, e.Type = start, t
+	return e
+}
+
+// unsupported reports that values cannot be unmarshaled into the Go type t.
+func (r *CBORReader) unsupported(t reflect.Type) error {
+	return r.errorAt(fmt.Errorf("%w: cannot unmarshal into %v", UnsupportedTypeReadError, t))
+}
+
+// structSpec returns the structure specification for t, learning it if it
+// is not yet cached.
+func (r *CBORReader) structSpec(t reflect.Type) *structCBORSpec {
+	scs, ok := r.scsCache[t]
+	if !ok {
+		scs = new(structCBORSpec)
+		scs.learnStruct(t)
+		r.scsCache[t] = scs
+	}
+	return scs
 }
 
 // readReflectedStruct attempts to deserialize a map from the reader that
@@ -647,30 +2067,130 @@ func (r *CBORReader) readReflectedStruct(pv reflect.Value) error {
 	if pv.Kind() != reflect.Struct {
 		return fmt.Errorf("readReflectedStruct wants only structs, got: %v", pv.Kind())
 	}
-	scs := structCBORSpec{}
-	scs.learnStruct(pv.Type())
+	scs := r.structSpec(pv.Type())
 
 	// Either read a string map or an int map or a tag.
 	ct, err := r.readType()
 	if err != nil {
-		return fmt.Errorf("failed to read tag: %v", err)
+		return err
 	}
+	r.pushbackType(ct)
 
-	var m map[string]interface{}
-	switch ct & majorSelect {
-	case majorMap:
-		// Read the right kind of map depending on what the struct supports.
-		// TODO: support reading int maps.
-		r.pushbackType(ct)
-		m, err = r.ReadStringMap()
+	switch {
+	case ct&majorSelect == majorTag && scs.hasTag:
+		start := r.offset()
+		tag, err := r.ReadTag()
 		if err != nil {
-			return fmt.Errorf("failed to read string map for struct: %v", err)
+			return err
 		}
-	case majorTag:
-		return errors.New("tagged structs are not supported yet")
+		if tag != CBORTag(scs.tag) {
+			e := r.errorAt(fmt.Errorf("%w: tag %d, expected %d", CBORTypeReadError, tag, scs.tag))
+			e.Offset, e.Type = start, TypeTag
+			return e
+		}
+	case scs.hasTag && !r.untagged:
+		return r.typeError(ct, TypeTag)
 	}
-	scs.convertStringMapToStruct(m, pv)
-	return nil
+
+	if ct, err = r.readType(); err != nil {
+		return err
+	}
+	r.pushbackType(ct)
+	if ct&majorSelect != majorMap {
+		return r.typeError(ct, TypeMap)
+	}
+
+	n, err := r.readContainer(majorMap)
+	if err != nil {
+		return err
+	}
+	if err := r.enter(); err != nil {
+		return err
+	}
+	defer r.leave()
+
+	// keys are only remembered if duplicates need handling
+	var seen map[interface{}]bool
+	if r.dupKeys != DupKeyKeepLast {
+		seen = make(map[interface{}]bool)
+	}
+
+	// read each value straight into the field for its key
+	for {
+		more, err := r.hasMore(&n)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+
+		// Read the right kind of key depending on what the struct supports.
+		start := r.offset()
+		k, i, ok, err := r.readStructKey(&n, scs)
+		if err != nil {
+			return err
+		}
+
+		keep := true
+		if seen != nil && k != nil {
+			if seen[k] {
+				if keep, err = r.duplicateKey(start, k); err != nil {
+					return err
+				}
+			}
+			seen[k] = true
+		}
+
+		if !ok || !keep {
+			// the value of a key which matches no field, or of a duplicate key
+			// being ignored, is discarded
+			if err := r.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		r.path = append(r.path, pathElem{kind: pathField, t: pv.Type(), index: i})
+		err = r.unmarshalValue(pv.Field(i))
+		r.path = r.path[:len(r.path)-1]
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readStructKey reads the next key of the map c, which is being read into a
+// struct with the specification scs. It returns the key, converted to a
+// string or int as the struct uses, and the index of the field it selects, if
+// any. Keys of an int-keyed struct which are not integers select no field,
+// and are returned as nil if they cannot be compared.
+func (r *CBORReader) readStructKey(c *container, scs *structCBORSpec) (interface{}, int, bool, error) {
+	k, err := r.readMapKey(c, r.Read)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	if scs.usingIntKeys() {
+		switch ki := k.(type) {
+		case int:
+			i, ok := scs.fieldForIntKey[ki]
+			return k, i, ok, nil
+		case uint64:
+			if ki <= math.MaxInt {
+				i, ok := scs.fieldForIntKey[int(ki)]
+				return int(ki), i, ok, nil
+			}
+		}
+		if k != nil && !reflect.ValueOf(k).Comparable() {
+			k = nil
+		}
+		return k, 0, false, nil
+	}
+
+	ks := mapKeyString(k)
+	i, ok := scs.fieldForStrKey[ks]
+	return ks, i, ok, nil
 }
 
 type CBORUnmarshaler interface {
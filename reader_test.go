@@ -2,9 +2,14 @@ package borat
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"math"
+	"math/big"
 	"reflect"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"gopkg.in/d4l3k/messagediff.v1"
 )
@@ -23,7 +28,7 @@ func cborDecoderHarness(t *testing.T, in []byte, expected interface{}) {
 
 func cborDecoderHarnessExpectErr(t *testing.T, in []byte, errExpect error) {
 	r := NewCBORReader(bytes.NewReader(in))
-	if _, err := r.Read(); err != errExpect {
+	if _, err := r.Read(); !errors.Is(err, errExpect) {
 		t.Errorf("expected error %v but got %v", errExpect, err)
 	}
 }
@@ -95,30 +100,55 @@ func TestReadInt(t *testing.T) {
 	}
 }
 
-// We do not support 16-bit floats at the moment. Test for expected functionality.
-func TestReadFloatUnsupported(t *testing.T) {
+func TestReadFloatHalf(t *testing.T) {
 	testPatterns := []struct {
-		cbor []byte
-		err  error
+		cbor  []byte
+		value float64
 	}{
-		{
-			// 0.0
-			[]byte{0xf9, 0x00, 0x00},
-			UnsupportedTypeReadError,
-		},
-		{
-			// -0.0
-			[]byte{0xf9, 0x80, 0x00},
-			UnsupportedTypeReadError,
-		},
-		{
-			// 65504.0
-			[]byte{0xf9, 0x7b, 0xff},
-			UnsupportedTypeReadError,
-		},
+		{[]byte{0xf9, 0x00, 0x00}, 0.0},
+		{[]byte{0xf9, 0x80, 0x00}, math.Copysign(0, -1)},
+		{[]byte{0xf9, 0x3c, 0x00}, 1.0},
+		{[]byte{0xf9, 0x3e, 0x00}, 1.5},
+		{[]byte{0xf9, 0x7b, 0xff}, 65504.0},
+		{[]byte{0xf9, 0x00, 0x01}, 5.960464477539063e-08},
+		{[]byte{0xf9, 0x04, 0x00}, 0.00006103515625},
+		{[]byte{0xf9, 0xc4, 0x00}, -4.0},
+		{[]byte{0xf9, 0x7c, 0x00}, math.Inf(1)},
+		{[]byte{0xf9, 0xfc, 0x00}, math.Inf(-1)},
 	}
 	for i := range testPatterns {
-		cborDecoderHarnessExpectErr(t, testPatterns[i].cbor, testPatterns[i].err)
+		cborDecoderHarness(t, testPatterns[i].cbor, testPatterns[i].value)
+	}
+	r := NewCBORReader(bytes.NewReader([]byte{0xf9, 0x7e, 0x00}))
+	if res, err := r.Read(); err != nil {
+		t.Errorf("expected no error decoding NaN but got: %v", err)
+	} else if !math.IsNaN(res.(float64)) {
+		t.Errorf("expected NaN but got %f", res)
+	}
+}
+
+func TestReadFloatHalfTyped(t *testing.T) {
+	var f float32
+	r := NewCBORReader(bytes.NewReader([]byte{0xf9, 0x3e, 0x00}))
+	if err := r.Unmarshal(&f); err != nil || f != 1.5 {
+		t.Errorf("expected 1.5 from unmarshal but got %v, %v", f, err)
+	}
+
+	times := []struct {
+		cbor  []byte
+		value time.Time
+	}{
+		{[]byte{0xc1, 0xf9, 0x3c, 0x00}, time.Unix(1, 0)},
+		{[]byte{0xf9, 0x3e, 0x00}, time.Unix(1, 500000000)},
+		{[]byte{0xc1, 0xfb, 0x41, 0xd4, 0x52, 0xd9, 0xec, 0x20, 0x00, 0x00}, time.Unix(1363896240, 500000000)},
+	}
+	for _, tp := range times {
+		r := NewCBORReader(bytes.NewReader(tp.cbor))
+		if got, err := r.ReadTime(); err != nil {
+			t.Errorf("expected no error reading time from % x but got: %v", tp.cbor, err)
+		} else if !got.Equal(tp.value) {
+			t.Errorf("reading time from % x: want %v, got %v", tp.cbor, tp.value, got)
+		}
 	}
 }
 
@@ -298,3 +328,969 @@ func TestReadToStruct(t *testing.T) {
 		t.Errorf("failed unmarshaling struct, got=%+v, diff=%s", got, diff)
 	}
 }
+
+func TestReadIndefinite(t *testing.T) {
+	testPatterns := []struct {
+		cbor  []byte
+		value interface{}
+	}{
+		{
+			[]byte{0x5f, 0x42, 0x01, 0x02, 0x43, 0x03, 0x04, 0x05, 0xff},
+			[]byte{0x01, 0x02, 0x03, 0x04, 0x05},
+		},
+		{
+			[]byte{0x7f, 0x65, 0x73, 0x74, 0x72, 0x65, 0x61, 0x64, 0x6d, 0x69, 0x6e, 0x67, 0xff},
+			"streaming",
+		},
+		{
+			[]byte{0x9f, 0xff},
+			[]interface{}{},
+		},
+		{
+			[]byte{0x9f, 0x01, 0x82, 0x02, 0x03, 0x9f, 0x04, 0x05, 0xff, 0xff},
+			[]interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}},
+		},
+		{
+			[]byte{0x83, 0x01, 0x9f, 0x02, 0x03, 0xff, 0x82, 0x04, 0x05},
+			[]interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}},
+		},
+		{
+			[]byte{0xbf, 0x61, 0x61, 0x01, 0x61, 0x62, 0x9f, 0x02, 0x03, 0xff, 0xff},
+			map[string]interface{}{
+				"a": uint64(1),
+				"b": []interface{}{uint64(2), uint64(3)},
+			},
+		},
+		{
+			[]byte{0xbf, 0x63, 0x46, 0x75, 0x6e, 0xf5, 0x63, 0x41, 0x6d, 0x74, 0x21, 0xff},
+			map[string]interface{}{
+				"Fun": true,
+				"Amt": -2,
+			},
+		},
+	}
+	for i := range testPatterns {
+		cborDecoderHarness(t, testPatterns[i].cbor, testPatterns[i].value)
+	}
+
+	// chunks of the wrong type, nested indefinite chunks and stray breaks
+	invalid := [][]byte{
+		{0x5f, 0x61, 0x61, 0xff},
+		{0x7f, 0x7f, 0xff, 0xff},
+		{0xff},
+	}
+	for _, b := range invalid {
+		cborDecoderHarnessExpectErr(t, b, InvalidCBORError)
+	}
+}
+
+func TestReadIndefiniteToStruct(t *testing.T) {
+	data := []byte{0xbf, 0x61, 0x41, 0x7f, 0x62, 0x68, 0x65, 0x63, 0x6c, 0x6c, 0x6f, 0xff,
+		0x61, 0x42, 0x9f, 0x61, 0x78, 0x61, 0x79, 0xff, 0xff}
+	type A struct {
+		A string
+		B []string
+	}
+	want := &A{
+		A: "hello",
+		B: []string{"x", "y"},
+	}
+	got := &A{}
+	r := NewCBORReader(bytes.NewReader(data))
+	if err := r.Unmarshal(got); err != nil {
+		t.Errorf("expected nil error from unmarshal but got: %v", err)
+	}
+	if ok := reflect.DeepEqual(want, got); !ok {
+		t.Errorf("failed unmarshaling struct: want %+v, got %+v", want, got)
+	}
+}
+
+func TestReadIntKeyedStruct(t *testing.T) {
+	type coseKey struct {
+		Kty int    `cbor:"#1"`
+		Kid string `cbor:"#2"`
+		Crv int    `cbor:"#-1"`
+		X   string `cbor:"#-2"`
+	}
+	want := coseKey{Kty: 2, Kid: "k", Crv: 1, X: "x"}
+
+	var buf bytes.Buffer
+	if err := NewCBORWriter(&buf).Marshal(want); err != nil {
+		t.Fatalf("failed to marshal int-keyed struct: %v", err)
+	}
+	var got coseKey
+	if err := NewCBORReader(bytes.NewReader(buf.Bytes())).Unmarshal(&got); err != nil || got != want {
+		t.Errorf("failed to unmarshal int-keyed struct: want %+v, got %+v, %v", want, got, err)
+	}
+
+	// {1: 2, 100: [1], -2: "x"}: unknown keys are skipped
+	got = coseKey{}
+	in := []byte{0xa3, 0x01, 0x02, 0x18, 0x64, 0x81, 0x01, 0x21, 0x61, 0x78}
+	if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&got); err != nil || got != (coseKey{Kty: 2, X: "x"}) {
+		t.Errorf("failed to unmarshal int-keyed struct with unknown key: got %+v, %v", got, err)
+	}
+
+	// {1: 5, "x": 1, h'01': 2, "x": 3}: labels which are not integers are
+	// skipped, as COSE header maps mix them
+	got = coseKey{}
+	in = []byte{0xa4, 0x01, 0x05, 0x61, 0x78, 0x01, 0x41, 0x01, 0x02, 0x61, 0x78, 0x03}
+	if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&got); err != nil || got != (coseKey{Kty: 5}) {
+		t.Errorf("failed to unmarshal int-keyed struct with mixed labels: got %+v, %v", got, err)
+	}
+	r := NewCBORReaderWithOptions(bytes.NewReader(in), DecOptions{DupKeys: DupKeyReject})
+	var dke *DuplicateKeyError
+	if err := r.Unmarshal(&got); !errors.As(err, &dke) || dke.Key != "x" {
+		t.Errorf("expected duplicate key error for label x but got %v", err)
+	}
+
+	// {148({1: 2}): 3}: a tag is comparable, but not with a map as content
+	in = []byte{0xa1, 0xd8, 0x94, 0xa1, 0x01, 0x02, 0x03}
+	for _, policy := range []DupKeyPolicy{DupKeyKeepFirst, DupKeyReject} {
+		got = coseKey{}
+		r := NewCBORReaderWithOptions(bytes.NewReader(in), DecOptions{DupKeys: policy})
+		if err := r.Unmarshal(&got); err != nil || got != (coseKey{}) {
+			t.Errorf("failed to skip tagged map label with policy %v: got %+v, %v", policy, got, err)
+		}
+	}
+}
+
+func TestReadTaggedStruct(t *testing.T) {
+	type signed struct {
+		cborTag struct{} `cbor:"99"`
+		A       int
+	}
+	type plain struct {
+		A int
+	}
+
+	var buf bytes.Buffer
+	if err := NewCBORWriter(&buf).Marshal(signed{A: 1}); err != nil {
+		t.Fatalf("failed to marshal tagged struct: %v", err)
+	}
+	tagged := []byte{0xd8, 0x63, 0xa1, 0x61, 0x41, 0x01}
+	if !bytes.Equal(buf.Bytes(), tagged) {
+		t.Errorf("expected tagged struct written as [% x] but got [% x]", tagged, buf.Bytes())
+	}
+
+	var s signed
+	if err := NewCBORReader(bytes.NewReader(tagged)).Unmarshal(&s); err != nil || s.A != 1 {
+		t.Errorf("failed to unmarshal tagged struct: %+v, %v", s, err)
+	}
+
+	// the tag must be present and match, unless untagged input is allowed
+	untagged := tagged[2:]
+	var de *DecodeError
+	if err := NewCBORReader(bytes.NewReader(untagged)).Unmarshal(&s); !errors.As(err, &de) || de.Expected != TypeTag {
+		t.Errorf("expected missing tag error but got %v", err)
+	}
+	s = signed{}
+	if err := NewCBORReaderWithOptions(bytes.NewReader(untagged), DecOptions{AllowUntagged: true}).Unmarshal(&s); err != nil || s.A != 1 {
+		t.Errorf("failed to unmarshal untagged struct allowing untagged input: %+v, %v", s, err)
+	}
+	wrong := []byte{0xd8, 0x64, 0xa1, 0x61, 0x41, 0x01}
+	if err := NewCBORReaderWithOptions(bytes.NewReader(wrong), DecOptions{AllowUntagged: true}).Unmarshal(&s); !errors.Is(err, CBORTypeReadError) {
+		t.Errorf("expected mismatched tag error but got %v", err)
+	}
+
+	// a struct without a tag is not read from tagged input
+	if err := NewCBORReader(bytes.NewReader(tagged)).Unmarshal(new(plain)); !errors.Is(err, CBORTypeReadError) {
+		t.Errorf("expected type error reading tagged input into untagged struct but got %v", err)
+	}
+}
+
+func TestReadTypedSlices(t *testing.T) {
+	type item struct {
+		A int
+	}
+	type typed struct {
+		Items  []item
+		Nested [][]string
+		Floats []float64
+		Triple [3]int
+		Key    [4]byte
+		Hash   [2]byte
+		Data   []byte
+	}
+
+	in := []byte{0xa7,
+		0x65, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x82, 0xa1, 0x61, 0x41, 0x01, 0xa1, 0x61, 0x41, 0x02,
+		0x66, 0x4e, 0x65, 0x73, 0x74, 0x65, 0x64, 0x82, 0x81, 0x61, 0x61, 0x80,
+		0x66, 0x46, 0x6c, 0x6f, 0x61, 0x74, 0x73, 0x82, 0xf9, 0x3e, 0x00, 0xfb, 0x40, 0x09, 0x21, 0xfb, 0x54, 0x44, 0x2d, 0x18,
+		0x66, 0x54, 0x72, 0x69, 0x70, 0x6c, 0x65, 0x9f, 0x01, 0x02, 0x03, 0xff,
+		0x63, 0x4b, 0x65, 0x79, 0x44, 0x01, 0x02, 0x03, 0x04,
+		0x64, 0x48, 0x61, 0x73, 0x68, 0x82, 0x18, 0xff, 0x00,
+		0x64, 0x44, 0x61, 0x74, 0x61, 0x42, 0x05, 0x06,
+	}
+	want := typed{
+		Items:  []item{{1}, {2}},
+		Nested: [][]string{{"a"}, {}},
+		Floats: []float64{1.5, math.Pi},
+		Triple: [3]int{1, 2, 3},
+		Key:    [4]byte{1, 2, 3, 4},
+		Hash:   [2]byte{0xff, 0x00},
+		Data:   []byte{5, 6},
+	}
+
+	var got typed
+	if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&got); err != nil {
+		t.Fatalf("failed to unmarshal typed slices: %v", err)
+	}
+	if diff, equal := messagediff.PrettyDiff(got, want); !equal {
+		t.Errorf("unexpected typed slices: %s", diff)
+	}
+
+	// arrays must be read from items of the same length
+	for _, b := range [][]byte{
+		{0x82, 0x01, 0x02},
+		{0x84, 0x01, 0x02, 0x03, 0x04},
+		{0x9f, 0x01, 0x02, 0xff},
+		{0x9f, 0x01, 0x02, 0x03, 0x04, 0xff},
+	} {
+		var a [3]int
+		if err := NewCBORReader(bytes.NewReader(b)).Unmarshal(&a); !errors.Is(err, CBORTypeReadError) {
+			t.Errorf("expected length error reading % x into [3]int but got %v", b, err)
+		}
+	}
+	var key [4]byte
+	if err := NewCBORReader(bytes.NewReader([]byte{0x43, 0x01, 0x02, 0x03})).Unmarshal(&key); !errors.Is(err, CBORTypeReadError) {
+		t.Errorf("expected length error reading 3 bytes into [4]byte but got %v", err)
+	}
+
+	// named byte types are read from byte strings too
+	type octet uint8
+	var octets [2]octet
+	if err := NewCBORReader(bytes.NewReader([]byte{0x42, 0x01, 0x02})).Unmarshal(&octets); err != nil || octets != [2]octet{1, 2} {
+		t.Errorf("expected [2]octet{1, 2} but got %v, %v", octets, err)
+	}
+	var octetSlice []octet
+	if err := NewCBORReader(bytes.NewReader([]byte{0x42, 0x03, 0x04})).Unmarshal(&octetSlice); err != nil || !reflect.DeepEqual(octetSlice, []octet{3, 4}) {
+		t.Errorf("expected []octet{3, 4} but got %v, %v", octetSlice, err)
+	}
+}
+
+func TestReadTypedMaps(t *testing.T) {
+	type item struct {
+		A int
+	}
+
+	// existing entries are kept, and non-string keys are coerced to strings
+	names := map[string]string{"b": "z"}
+	in := []byte{0xa2, 0x61, 0x61, 0x61, 0x78, 0x01, 0x61, 0x79}
+	if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&names); err != nil {
+		t.Fatalf("failed to unmarshal map[string]string: %v", err)
+	}
+	if diff, equal := messagediff.PrettyDiff(names, map[string]string{"a": "x", "1": "y", "b": "z"}); !equal {
+		t.Errorf("unexpected map[string]string: %s", diff)
+	}
+
+	var data map[uint32][]byte
+	in = []byte{0xa2, 0x01, 0x41, 0x01, 0x18, 0xff, 0x40}
+	if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&data); err != nil {
+		t.Fatalf("failed to unmarshal map[uint32][]byte: %v", err)
+	}
+	if diff, equal := messagediff.PrettyDiff(data, map[uint32][]byte{1: {1}, 255: {}}); !equal {
+		t.Errorf("unexpected map[uint32][]byte: %s", diff)
+	}
+
+	var items map[int8]item
+	in = []byte{0xa2, 0x38, 0x7f, 0xa1, 0x61, 0x41, 0x05, 0x18, 0x7f, 0xa0}
+	if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&items); err != nil {
+		t.Fatalf("failed to unmarshal map[int8]item: %v", err)
+	}
+	if diff, equal := messagediff.PrettyDiff(items, map[int8]item{-128: {5}, 127: {}}); !equal {
+		t.Errorf("unexpected map[int8]item: %s", diff)
+	}
+
+	// integer keys must fit the key type
+	for _, tc := range []struct {
+		in  []byte
+		out interface{}
+	}{
+		{[]byte{0xa1, 0x18, 0x80, 0xf5}, &map[int8]bool{}},
+		{[]byte{0xa1, 0x38, 0x80, 0xf5}, &map[int8]bool{}},
+		{[]byte{0xa1, 0x20, 0xf5}, &map[uint8]bool{}},
+		{[]byte{0xa1, 0x19, 0x01, 0x00, 0xf5}, &map[uint8]bool{}},
+		{[]byte{0xa1, 0x3b, 0x80, 0, 0, 0, 0, 0, 0, 0, 0xf5}, &map[int64]bool{}},
+	} {
+		err := NewCBORReader(bytes.NewReader(tc.in)).Unmarshal(tc.out)
+		var de *DecodeError
+		if !errors.As(err, &de) || !errors.Is(err, OverflowReadError) || de.Offset != 1 {
+			t.Errorf("expected overflow at offset 1 reading % x into %T but got %v", tc.in, tc.out, err)
+		}
+	}
+
+	// values are converted too, and errors give their path
+	var counts map[string]int
+	err := NewCBORReader(bytes.NewReader([]byte{0xa1, 0x61, 0x6e, 0x61, 0x31})).Unmarshal(&counts)
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Path != "map[string]int[n]" {
+		t.Errorf("expected type error at map[string]int[n] but got %v", err)
+	}
+
+	// duplicate keys follow the reader's policy
+	in = []byte{0xa2, 0x01, 0x61, 0x61, 0x01, 0x61, 0x62}
+	first := map[int]string{}
+	r := NewCBORReaderWithOptions(bytes.NewReader(in), DecOptions{DupKeys: DupKeyKeepFirst})
+	if err := r.Unmarshal(&first); err != nil || first[1] != "a" {
+		t.Errorf("expected first duplicate to be kept but got %v, %v", first, err)
+	}
+	r = NewCBORReaderWithOptions(bytes.NewReader(in), DecOptions{DupKeys: DupKeyReject})
+	var dke *DuplicateKeyError
+	if err := r.Unmarshal(&map[int]string{}); !errors.As(err, &dke) {
+		t.Errorf("expected duplicate key error but got %v", err)
+	}
+
+	// keys read into interface{} must be usable as map keys
+	for _, tc := range []struct {
+		in   []byte
+		out  interface{}
+		opts DecOptions
+	}{
+		{[]byte{0xa1, 0x41, 0x01, 0x01}, &map[interface{}]interface{}{}, DecOptions{}},
+		{[]byte{0xa1, 0x81, 0x01, 0x01}, &map[interface{}]int{}, DecOptions{DupKeys: DupKeyReject}},
+	} {
+		err := NewCBORReaderWithOptions(bytes.NewReader(tc.in), tc.opts).Unmarshal(tc.out)
+		var de *DecodeError
+		if !errors.As(err, &de) || !errors.Is(err, UnsupportedTypeReadError) || de.Offset != 1 {
+			t.Errorf("expected unsupported key at offset 1 reading % x into %T but got %v", tc.in, tc.out, err)
+		}
+	}
+}
+
+func TestReadPointers(t *testing.T) {
+	type inner struct {
+		N int
+	}
+	type item struct {
+		P   *inner
+		I   *int
+		B   *big.Int
+		S   []string
+		M   map[string]int
+		Any interface{}
+	}
+
+	// values allocate nil pointers, and interfaces get what Read returns
+	in := []byte{0xa6,
+		0x61, 0x50, 0xa1, 0x61, 0x4e, 0x01,
+		0x61, 0x49, 0x02,
+		0x61, 0x42, 0xc2, 0x49, 0x01, 0, 0, 0, 0, 0, 0, 0, 0,
+		0x61, 0x53, 0xf6,
+		0x61, 0x4d, 0xf6,
+		0x63, 0x41, 0x6e, 0x79, 0xa1, 0x61, 0x6b, 0x81, 0x03,
+	}
+	var got item
+	if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&got); err != nil {
+		t.Fatalf("failed to unmarshal pointers: %v", err)
+	}
+	i := 2
+	b := new(big.Int).Lsh(big.NewInt(1), 64)
+	want := item{
+		P:   &inner{1},
+		I:   &i,
+		B:   b,
+		Any: map[string]interface{}{"k": []interface{}{uint64(3)}},
+	}
+	if diff, equal := messagediff.PrettyDiff(got, want); !equal {
+		t.Errorf("unexpected pointers: %s", diff)
+	}
+
+	// null sets pointers, slices, maps and interfaces to nil
+	got = item{P: &inner{1}, I: &i, S: []string{"a"}, M: map[string]int{"a": 1}, Any: 1}
+	in = []byte{0xa5,
+		0x61, 0x50, 0xf6,
+		0x61, 0x49, 0xf6,
+		0x61, 0x53, 0xf6,
+		0x61, 0x4d, 0xf6,
+		0x63, 0x41, 0x6e, 0x79, 0xf6,
+	}
+	if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&got); err != nil {
+		t.Fatalf("failed to unmarshal nulls: %v", err)
+	}
+	if diff, equal := messagediff.PrettyDiff(got, item{}); !equal {
+		t.Errorf("unexpected nulls: %s", diff)
+	}
+
+	// there is nothing to unmarshal into without a pointer
+	for _, x := range []interface{}{nil, (*item)(nil), item{}} {
+		if err := NewCBORReader(bytes.NewReader([]byte{0xa0})).Unmarshal(x); err == nil {
+			t.Errorf("expected error unmarshaling into %#v", x)
+		}
+	}
+
+	// existing pointers are read through
+	p := &inner{}
+	pp := &p
+	if err := NewCBORReader(bytes.NewReader([]byte{0xa1, 0x61, 0x4e, 0x07})).Unmarshal(&pp); err != nil || p.N != 7 {
+		t.Errorf("expected to read through pointer but got %v, %v", p, err)
+	}
+}
+
+func TestReadTagged(t *testing.T) {
+	testPatterns := []struct {
+		cbor  []byte
+		value interface{}
+	}{
+		{
+			[]byte{0xd7, 0x44, 0x01, 0x02, 0x03, 0x04},
+			Tag{Number: 23, Content: []byte{0x01, 0x02, 0x03, 0x04}},
+		},
+		{
+			[]byte{0xd8, 0x20, 0x76, 0x68, 0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f, 0x77,
+				0x77, 0x77, 0x2e, 0x65, 0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x2e,
+				0x63, 0x6f, 0x6d},
+			Tag{Number: TagURI, Content: "http://www.example.com"},
+		},
+		{
+			[]byte{0x82, 0xd8, 0x64, 0xd8, 0x65, 0x61, 0x61, 0x21},
+			[]interface{}{Tag{Number: 100, Content: Tag{Number: 101, Content: "a"}}, -2},
+		},
+		{
+			[]byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0},
+			time.Unix(1363896240, 0),
+		},
+	}
+	for i := range testPatterns {
+		cborDecoderHarness(t, testPatterns[i].cbor, testPatterns[i].value)
+
+		// unknown tags must survive a decode/encode round trip
+		r := NewCBORReader(bytes.NewReader(testPatterns[i].cbor))
+		v, err := r.Read()
+		if err != nil {
+			t.Errorf("failed to decode % x: %v", testPatterns[i].cbor, err)
+			continue
+		}
+		if _, ok := v.(time.Time); ok {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := NewCBORWriter(&buf).Marshal(v); err != nil {
+			t.Errorf("failed to encode %v: %v", v, err)
+		} else if !bytes.Equal(buf.Bytes(), testPatterns[i].cbor) {
+			t.Errorf("round trip of % x produced % x", testPatterns[i].cbor, buf.Bytes())
+		}
+	}
+
+	// content is decoded and encoded as any other value: the tag and the
+	// value of its content survive, but not necessarily its encoding
+	for _, tc := range []struct {
+		in, out []byte
+		value   Tag
+	}{
+		{[]byte{0xd8, 0x63, 0xa1, 0x01, 0x02}, []byte{0xd8, 0x63, 0xa1, 0x61, 0x31, 0x02},
+			Tag{Number: 99, Content: map[string]interface{}{"1": uint64(2)}}},
+		{[]byte{0xd8, 0x63, 0xf9, 0x3e, 0x00}, []byte{0xd8, 0x63, 0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0},
+			Tag{Number: 99, Content: 1.5}},
+	} {
+		v, err := NewCBORReader(bytes.NewReader(tc.in)).Read()
+		if diff, equal := messagediff.PrettyDiff(v, tc.value); err != nil || !equal {
+			t.Errorf("unexpected tag decoding % x: %s, %v", tc.in, diff, err)
+		}
+		var buf bytes.Buffer
+		if err := NewCBORWriter(&buf).Marshal(v); err != nil || !bytes.Equal(buf.Bytes(), tc.out) {
+			t.Errorf("expected %v encoded as % x but got % x, %v", v, tc.out, buf.Bytes(), err)
+		}
+
+		// a RawMessage keeps the encoding as read
+		raw, err := NewCBORReader(bytes.NewReader(tc.in)).ReadRaw()
+		buf.Reset()
+		if err == nil {
+			err = NewCBORWriter(&buf).Marshal(raw)
+		}
+		if err != nil || !bytes.Equal(buf.Bytes(), tc.in) {
+			t.Errorf("expected raw round trip of % x but got % x, %v", tc.in, buf.Bytes(), err)
+		}
+	}
+
+	var tag Tag
+	r := NewCBORReader(bytes.NewReader([]byte{0xd8, 0x64, 0xf6}))
+	if err := r.Unmarshal(&tag); err != nil {
+		t.Errorf("expected nil error from unmarshal but got: %v", err)
+	} else if tag.Number != 100 || tag.Content != nil {
+		t.Errorf("unexpected tag from unmarshal: %+v", tag)
+	}
+}
+
+func TestReadFragmentedInput(t *testing.T) {
+	long := bytes.Repeat([]byte("borat"), 2000)
+	var buf bytes.Buffer
+	w := NewCBORWriter(&buf)
+	w.WriteStringMap(map[string]interface{}{
+		"bytes":  long,
+		"string": string(long[:300]),
+		"ints":   []int{1, 1000, 100000},
+	})
+	w.WriteInt(-1000)
+
+	r := NewCBORReader(iotest.OneByteReader(bytes.NewReader(buf.Bytes())))
+	m, err := r.ReadStringMap()
+	if err != nil {
+		t.Fatalf("failed to read fragmented map: %v", err)
+	}
+	if !bytes.Equal(m["bytes"].([]byte), long) || m["string"] != string(long[:300]) || len(m["ints"].([]interface{})) != 3 {
+		t.Errorf("unexpected map read from fragmented input: %v", m)
+	}
+	if i, err := r.ReadInt(); err != nil || i != -1000 {
+		t.Errorf("expected -1000 after map but got %v, %v", i, err)
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("expected io.EOF at end of input but got %v", err)
+	}
+}
+
+func TestReadTruncatedInput(t *testing.T) {
+	truncated := [][]byte{
+		{0x19, 0x01},
+		{0x63, 0x61, 0x62},
+		{0x82, 0x01},
+		{0x9f, 0x01},
+		{0xa1, 0x61, 0x61},
+		{0x7f, 0x61, 0x61},
+		{0xd8, 0x20},
+		{0xc2},
+		{0x5b, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+	}
+	for _, b := range truncated {
+		cborDecoderHarnessExpectErr(t, b, io.ErrUnexpectedEOF)
+	}
+	cborDecoderHarnessExpectErr(t, []byte{}, io.EOF)
+}
+
+func TestReadDecodeError(t *testing.T) {
+	type inner struct {
+		Count int
+	}
+	type outer struct {
+		Name  string
+		Inner inner
+	}
+	type list struct {
+		Items []inner
+	}
+
+	// {"Name": "a", "Inner": {"Count": "x"}}
+	in := []byte{0xa2, 0x64, 0x4e, 0x61, 0x6d, 0x65, 0x61, 0x61,
+		0x65, 0x49, 0x6e, 0x6e, 0x65, 0x72, 0xa1, 0x65, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x61, 0x78}
+
+	var o outer
+	err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&o)
+	var de *DecodeError
+	if !errors.As(err, &de) {
+		t.Fatalf("expected *DecodeError but got %v", err)
+	}
+	if !errors.Is(err, CBORTypeReadError) {
+		t.Errorf("expected error to wrap CBORTypeReadError: %v", err)
+	}
+	if de.Offset != 21 || de.Type != TypeString || de.Expected != TypeUnsigned || de.Path != "outer.Inner.Count" {
+		t.Errorf("unexpected decode error: %#v", de)
+	}
+
+	// {"Items": [{"Count": 1}, {"Count": -1.5}]}
+	in = []byte{0xa1, 0x65, 0x49, 0x74, 0x65, 0x6d, 0x73, 0x82, 0xa1, 0x65, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x01,
+		0xa1, 0x65, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0xf9, 0xbe, 0x00}
+	var l list
+	err = NewCBORReader(bytes.NewReader(in)).Unmarshal(&l)
+	if !errors.As(err, &de) || de.Path != "list.Items[1].Count" || de.Offset != 23 {
+		t.Errorf("unexpected error for slice element: %v", err)
+	}
+
+	// a reserved additional information value
+	if _, err := NewCBORReader(bytes.NewReader([]byte{0x82, 0x01, 0x1c})).Read(); !errors.As(err, &de) || de.Offset != 2 || !errors.Is(err, InvalidCBORError) {
+		t.Errorf("unexpected error for invalid item: %v", err)
+	}
+}
+
+func TestReadPathAllocs(t *testing.T) {
+	// the path is only formatted for errors, and slices are grown in place,
+	// so decoding each element must not allocate
+	items := bytes.Repeat([]byte{0x19, 0x01, 0x00}, 1000)
+	definite := append([]byte{0x99, 0x03, 0xe8}, items...)
+	indefinite := append(append([]byte{0x9f}, items...), 0xff)
+	for _, in := range [][]byte{definite, indefinite} {
+		var out []int64
+		allocs := testing.AllocsPerRun(10, func() {
+			if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&out); err != nil {
+				t.Fatalf("failed to unmarshal []int64: %v", err)
+			}
+		})
+		if allocs > 50 {
+			t.Errorf("unmarshaling 1000 integers from % x... took %v allocations", in[:1], allocs)
+		}
+		if len(out) != 1000 || out[0] != 256 || out[999] != 256 {
+			t.Errorf("unexpected integers from % x...: %d of them", in[:1], len(out))
+		}
+	}
+}
+
+func TestReadMapPathAllocs(t *testing.T) {
+	// {0: 256, 1: 256, ...}: map keys are only formatted for errors
+	in := []byte{0xb9, 0x03, 0xe8}
+	for i := 0; i < 1000; i++ {
+		in = append(in, 0x19, byte(i>>8), byte(i), 0x19, 0x01, 0x00)
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		var out map[int]int
+		if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&out); err != nil || len(out) != 1000 {
+			t.Fatalf("failed to unmarshal map[int]int: %d entries, %v", len(out), err)
+		}
+	})
+	if allocs > 50 {
+		t.Errorf("unmarshaling 1000 map entries took %v allocations", allocs)
+	}
+
+	// the entry being read is still reported for errors
+	in = []byte{0xa2, 0x01, 0x02, 0x19, 0x01, 0x00, 0x61, 0x78}
+	var out map[int]int
+	var de *DecodeError
+	if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&out); !errors.As(err, &de) || de.Path != "map[int]int[256]" {
+		t.Errorf("expected error at map[int]int[256] but got %v", err)
+	}
+}
+
+func TestReadLimits(t *testing.T) {
+	expectLimit := func(opts DecOptions, in []byte, limit string, offset int64) {
+		t.Helper()
+		_, err := NewCBORReaderWithOptions(bytes.NewReader(in), opts).Read()
+		var le *LimitError
+		var de *DecodeError
+		if !errors.As(err, &le) || !errors.As(err, &de) || le.Limit != limit || de.Offset != offset {
+			t.Errorf("expected %s exceeded at offset %d for input % x but got %v", limit, offset, in, err)
+		}
+	}
+
+	deep := append(bytes.Repeat([]byte{0x81}, 40), 0x01)
+	expectLimit(DecOptions{}, deep, "MaxDepth", 33)
+	expectLimit(DecOptions{}, append(bytes.Repeat([]byte{0xc6}, 40), 0x01), "MaxDepth", 33)
+	if _, err := NewCBORReaderWithOptions(bytes.NewReader(deep), DecOptions{MaxDepth: -1}).Read(); err != nil {
+		t.Errorf("failed to read deep array without depth limit: %v", err)
+	}
+
+	// huge declared lengths fail before anything is allocated
+	expectLimit(DecOptions{}, []byte{0x9b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "MaxArrayLen", 0)
+	expectLimit(DecOptions{}, []byte{0x81, 0xbb, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "MaxMapLen", 1)
+	expectLimit(DecOptions{MaxStringLen: 1024}, []byte{0x5b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "MaxStringLen", 0)
+	expectLimit(DecOptions{MaxBytes: 1024}, []byte{0x7b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "MaxBytes", 0)
+
+	// indefinite lengths are counted as they are read
+	expectLimit(DecOptions{MaxArrayLen: 2}, []byte{0x9f, 0x01, 0x02, 0x03, 0xff}, "MaxArrayLen", 0)
+	expectLimit(DecOptions{MaxMapLen: 1}, []byte{0xbf, 0x01, 0x02, 0x03, 0x04, 0xff}, "MaxMapLen", 0)
+	expectLimit(DecOptions{MaxStringLen: 3}, []byte{0x5f, 0x42, 0x01, 0x02, 0x42, 0x03, 0x04, 0xff}, "MaxStringLen", 0)
+
+	// MaxBytes applies to each top-level data item
+	r := NewCBORReaderWithOptions(bytes.NewReader([]byte{0x82, 0x01, 0x02, 0x82, 0x03, 0x04, 0x83, 0x05, 0x06, 0x07}), DecOptions{MaxBytes: 3})
+	for i := 0; i < 2; i++ {
+		if _, err := r.ReadArray(); err != nil {
+			t.Errorf("failed to read array %d within MaxBytes: %v", i, err)
+		}
+	}
+	if _, err := r.ReadArray(); !errors.As(err, new(*LimitError)) {
+		t.Errorf("expected MaxBytes exceeded but got %v", err)
+	}
+}
+
+func TestReadStrict(t *testing.T) {
+	// {1: "a", "b": [1.5]}
+	canonical := []byte{0xa2, 0x01, 0x61, 0x61, 0x61, 0x62, 0x81, 0xf9, 0x3e, 0x00}
+	if _, err := NewCBORReaderWithOptions(bytes.NewReader(canonical), DecOptions{Strict: true}).Read(); err != nil {
+		t.Errorf("failed to read canonical input in strict mode: %v", err)
+	}
+
+	testPatterns := []struct {
+		cbor   []byte
+		rule   string
+		offset int64
+	}{
+		{[]byte{0x18, 0x05}, "non-minimal header", 0},
+		{[]byte{0x81, 0x39, 0x00, 0x10}, "non-minimal header", 1},
+		{[]byte{0x5a, 0x00, 0x00, 0x00, 0x01, 0x00}, "non-minimal header", 0},
+		{[]byte{0xd8, 0x01, 0x00}, "non-minimal header", 0},
+		{[]byte{0x9f, 0x01, 0xff}, "indefinite length", 0},
+		{[]byte{0x7f, 0x61, 0x61, 0xff}, "indefinite length", 0},
+		{[]byte{0xa2, 0x61, 0x62, 0x01, 0x61, 0x61, 0x02}, "map keys not sorted", 4},
+		{[]byte{0xa2, 0x62, 0x61, 0x61, 0x01, 0x61, 0x62, 0x02}, "map keys not sorted", 5},
+		{[]byte{0xa2, 0x61, 0x61, 0x01, 0x61, 0x61, 0x02}, "duplicate map key", 4},
+		{[]byte{0xfa, 0x3f, 0xc0, 0x00, 0x00}, "float not in shortest form", 0},
+		{[]byte{0xfb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, "float not in shortest form", 0},
+		{[]byte{0xf9, 0x7e, 0x01}, "non-canonical NaN", 0},
+		{[]byte{0xfb, 0x7f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, "non-canonical NaN", 0},
+		{[]byte{0xc2, 0x41, 0x01}, "bignum not in shortest form", 1},
+		{[]byte{0xc3, 0x49, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "bignum not in shortest form", 1},
+	}
+
+	for _, tp := range testPatterns {
+		if _, err := NewCBORReader(bytes.NewReader(tp.cbor)).Read(); err != nil {
+			t.Errorf("failed to read % x outside strict mode: %v", tp.cbor, err)
+		}
+
+		_, err := NewCBORReaderWithOptions(bytes.NewReader(tp.cbor), DecOptions{Strict: true}).Read()
+		var ce *CanonicalError
+		var de *DecodeError
+		if !errors.As(err, &ce) || !errors.As(err, &de) || ce.Rule != tp.rule || de.Offset != tp.offset {
+			t.Errorf("expected %q at offset %d for input % x but got %v", tp.rule, tp.offset, tp.cbor, err)
+		}
+	}
+}
+
+func TestReadDuplicateKeys(t *testing.T) {
+	// {"a": 1, 1: 2, "a": 3, "1": 4}
+	strMap := []byte{0xa4, 0x61, 0x61, 0x01, 0x01, 0x02, 0x61, 0x61, 0x03, 0x61, 0x31, 0x04}
+	// {1: "a", -1: "b", 1: "c"}
+	intMap := []byte{0xa3, 0x01, 0x61, 0x61, 0x20, 0x61, 0x62, 0x01, 0x61, 0x63}
+	type keyed struct {
+		A int `cbor:"a"`
+	}
+
+	testPatterns := []struct {
+		policy DupKeyPolicy
+		strMap map[string]interface{}
+		intMap map[int]interface{}
+		keyed  keyed
+	}{
+		{DupKeyKeepLast,
+			map[string]interface{}{"a": uint64(3), "1": uint64(4)},
+			map[int]interface{}{1: "c", -1: "b"},
+			keyed{3}},
+		{DupKeyKeepFirst,
+			map[string]interface{}{"a": uint64(1), "1": uint64(2)},
+			map[int]interface{}{1: "a", -1: "b"},
+			keyed{1}},
+	}
+
+	for _, tp := range testPatterns {
+		opts := DecOptions{DupKeys: tp.policy}
+		sm, err := NewCBORReaderWithOptions(bytes.NewReader(strMap), opts).ReadStringMap()
+		if diff, equal := messagediff.PrettyDiff(sm, tp.strMap); err != nil || !equal {
+			t.Errorf("unexpected string map for policy %d: %v, %s", tp.policy, err, diff)
+		}
+		im, err := NewCBORReaderWithOptions(bytes.NewReader(intMap), opts).ReadIntMap()
+		if diff, equal := messagediff.PrettyDiff(im, tp.intMap); err != nil || !equal {
+			t.Errorf("unexpected int map for policy %d: %v, %s", tp.policy, err, diff)
+		}
+		var k keyed
+		if err := NewCBORReaderWithOptions(bytes.NewReader(strMap), opts).Unmarshal(&k); err != nil || k != tp.keyed {
+			t.Errorf("unexpected struct for policy %d: %v, %v", tp.policy, k, err)
+		}
+	}
+
+	// the values of ignored duplicates are skipped, not decoded: Read would
+	// reject undefined
+	opts := DecOptions{DupKeys: DupKeyKeepFirst}
+	in := []byte{0xa2, 0x61, 0x61, 0x01, 0x61, 0x61, 0xf7}
+	if sm, err := NewCBORReaderWithOptions(bytes.NewReader(in), opts).ReadStringMap(); err != nil || sm["a"] != uint64(1) {
+		t.Errorf("expected duplicate value to be skipped but got %v, %v", sm, err)
+	}
+	in = []byte{0xa2, 0x01, 0x61, 0x61, 0x01, 0xf7}
+	if im, err := NewCBORReaderWithOptions(bytes.NewReader(in), opts).ReadIntMap(); err != nil || im[1] != "a" {
+		t.Errorf("expected duplicate value to be skipped but got %v, %v", im, err)
+	}
+
+	opts = DecOptions{DupKeys: DupKeyReject}
+	expectDup := func(err error, key interface{}, offset int64) {
+		t.Helper()
+		var dk *DuplicateKeyError
+		var de *DecodeError
+		if !errors.As(err, &dk) || !errors.As(err, &de) || dk.Key != key || de.Offset != offset {
+			t.Errorf("expected duplicate key %v at offset %d but got %v", key, offset, err)
+		}
+	}
+	_, err := NewCBORReaderWithOptions(bytes.NewReader(strMap), opts).Read()
+	expectDup(err, "a", 6)
+	_, err = NewCBORReaderWithOptions(bytes.NewReader(intMap), opts).ReadIntMap()
+	expectDup(err, 1, 7)
+	err = NewCBORReaderWithOptions(bytes.NewReader(strMap), opts).Unmarshal(new(keyed))
+	expectDup(err, "a", 6)
+
+	// keys which only collide after conversion to strings are duplicates too
+	_, err = NewCBORReaderWithOptions(bytes.NewReader([]byte{0xa2, 0x01, 0x00, 0x61, 0x31, 0x00}), opts).ReadStringMap()
+	expectDup(err, "1", 3)
+}
+
+func TestReadInvalidUTF8(t *testing.T) {
+	testPatterns := []struct {
+		cbor   []byte
+		offset int64
+		index  int
+	}{
+		{[]byte{0x63, 0x61, 0xff, 0x62}, 2, 1},
+		{[]byte{0xa1, 0x61, 0xc3, 0x01}, 2, 0},
+		// each chunk must be valid on its own
+		{[]byte{0x7f, 0x62, 0x61, 0xc3, 0x61, 0xa9, 0xff}, 3, 1},
+	}
+
+	for _, tp := range testPatterns {
+		_, err := NewCBORReader(bytes.NewReader(tp.cbor)).Read()
+		var ue *InvalidUTF8Error
+		var de *DecodeError
+		if !errors.As(err, &ue) || !errors.As(err, &de) || ue.Offset != tp.index || de.Offset != tp.offset {
+			t.Errorf("expected invalid UTF-8 at offset %d for input % x but got %v", tp.offset, tp.cbor, err)
+		}
+
+		opts := DecOptions{AllowInvalidUTF8: true}
+		if _, err := NewCBORReaderWithOptions(bytes.NewReader(tp.cbor), opts).Read(); err != nil {
+			t.Errorf("failed to read % x allowing invalid UTF-8: %v", tp.cbor, err)
+		}
+	}
+
+	cborDecoderHarness(t, []byte{0x7f, 0x61, 0x61, 0x62, 0xc3, 0xa9, 0x61, 0x62, 0xff}, "aéb")
+}
+
+func TestRawMessage(t *testing.T) {
+	type envelope struct {
+		Kind string
+		Body RawMessage
+	}
+
+	// {"Body": [_ 1, {"a": h'01'}], "Kind": "x"}
+	body := []byte{0x9f, 0x01, 0xa1, 0x61, 0x61, 0x41, 0x01, 0xff}
+	in := append(append([]byte{0xa2, 0x64, 0x42, 0x6f, 0x64, 0x79}, body...), 0x64, 0x4b, 0x69, 0x6e, 0x64, 0x61, 0x78)
+
+	var e envelope
+	if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&e); err != nil {
+		t.Fatalf("failed to unmarshal envelope: %v", err)
+	}
+	if e.Kind != "x" || !bytes.Equal(e.Body, body) {
+		t.Errorf("unexpected envelope: %v, body % x", e.Kind, []byte(e.Body))
+	}
+
+	// the body is written back unchanged
+	var buf bytes.Buffer
+	if err := NewCBORWriter(&buf).Marshal(e); err != nil || !bytes.Equal(buf.Bytes(), in) {
+		t.Errorf("unexpected envelope written: [% x], %v", buf.Bytes(), err)
+	}
+
+	r := NewCBORReader(bytes.NewReader([]byte{0xc1, 0x01, 0x62, 0x61, 0x62}))
+	for _, expected := range [][]byte{{0xc1, 0x01}, {0x62, 0x61, 0x62}} {
+		if raw, err := r.ReadRaw(); err != nil || !bytes.Equal(raw, expected) {
+			t.Errorf("expected raw % x but got % x, %v", expected, []byte(raw), err)
+		}
+	}
+	if _, err := r.ReadRaw(); err != io.EOF {
+		t.Errorf("expected io.EOF after last raw item but got %v", err)
+	}
+
+	for _, bad := range []RawMessage{{}, {0x82, 0x01}, {0x01, 0x02}, {0x1c}} {
+		if err := NewCBORWriter(new(bytes.Buffer)).Marshal(bad); err == nil {
+			t.Errorf("expected error marshaling raw message % x", []byte(bad))
+		}
+	}
+	// undefined and simple values are well-formed
+	in = []byte{0xa2, 0x64, 0x42, 0x6f, 0x64, 0x79, 0x82, 0xf7, 0xf8, 0x20, 0x64, 0x4b, 0x69, 0x6e, 0x64, 0x61, 0x78}
+	if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&e); err != nil || !bytes.Equal(e.Body, []byte{0x82, 0xf7, 0xf8, 0x20}) {
+		t.Errorf("unexpected body with simple values: % x, %v", []byte(e.Body), err)
+	}
+	for _, ok := range []RawMessage{{0xf7}, {0xe5}, {0xf8, 0xff}} {
+		buf.Reset()
+		if err := NewCBORWriter(&buf).Marshal(ok); err != nil || !bytes.Equal(buf.Bytes(), ok) {
+			t.Errorf("expected raw message % x written unchanged but got [% x], %v", []byte(ok), buf.Bytes(), err)
+		}
+	}
+
+	buf.Reset()
+	if err := NewCBORWriter(&buf).Marshal(RawMessage(nil)); err != nil || !bytes.Equal(buf.Bytes(), []byte{0xf6}) {
+		t.Errorf("expected nil raw message written as null but got [% x], %v", buf.Bytes(), err)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	items := [][]byte{
+		{0x18, 0x64},
+		{0x3a, 0x00, 0x01, 0x86, 0x9f},
+		{0x5f, 0x42, 0x01, 0x02, 0x41, 0x03, 0xff},
+		{0x7f, 0x61, 0x61, 0x61, 0x62, 0xff},
+		{0xbf, 0x61, 0x61, 0x9f, 0x01, 0x82, 0x02, 0x03, 0xff, 0x01, 0xc1, 0xfb, 0x41, 0xd4, 0x52, 0xd9, 0xe8, 0x00, 0x00, 0x00, 0xff},
+		{0xd8, 0x20, 0xa1, 0x00, 0xf9, 0x3c, 0x00},
+		{0xf4},
+		{0xf6},
+		{0xf7},
+		{0xe0},
+		{0xf3},
+		{0xf8, 0x20},
+		{0xf8, 0xff},
+	}
+
+	var in []byte
+	for _, b := range items {
+		in = append(in, b...)
+	}
+	in = append(in, 0x07)
+
+	r := NewCBORReader(bytes.NewReader(in))
+	for _, b := range items {
+		if err := r.Skip(); err != nil {
+			t.Errorf("failed to skip % x: %v", b, err)
+		}
+	}
+	if i, err := r.ReadInt(); err != nil || i != 7 {
+		t.Errorf("expected 7 after skipped items but got %v, %v", i, err)
+	}
+
+	// skipped items are still checked
+	for _, b := range [][]byte{{0x82, 0x01}, {0x1c}, {0xf8, 0x1f}, {0xfc}, {0xfe}, {0xff}, {0x5f, 0x61, 0x61, 0xff}} {
+		if err := NewCBORReader(bytes.NewReader(b)).Skip(); err == nil {
+			t.Errorf("expected error skipping % x", b)
+		}
+	}
+	// unknown struct fields may hold any well-formed item
+	var known struct{ A int }
+	in = []byte{0xa2, 0x61, 0x41, 0x01, 0x61, 0x42, 0xf7}
+	if err := NewCBORReader(bytes.NewReader(in)).Unmarshal(&known); err != nil || known.A != 1 {
+		t.Errorf("expected undefined field to be skipped but got %v, %v", known, err)
+	}
+
+	deep := append(bytes.Repeat([]byte{0x81}, 40), 0x01)
+	if err := NewCBORReader(bytes.NewReader(deep)).Skip(); !errors.As(err, new(*LimitError)) {
+		t.Errorf("expected limit error skipping deep array but got %v", err)
+	}
+	unsorted := []byte{0xa2, 0x61, 0x62, 0x01, 0x61, 0x61, 0x02}
+	if err := NewCBORReaderWithOptions(bytes.NewReader(unsorted), DecOptions{Strict: true}).Skip(); !errors.As(err, new(*CanonicalError)) {
+		t.Errorf("expected canonical error skipping unsorted map but got %v", err)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	testPatterns := []struct {
+		cbor  []byte
+		typ   CBORType
+		u     uint64
+		indef bool
+	}{
+		{[]byte{0x17}, TypeUnsigned, 23, false},
+		{[]byte{0x3b, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}, TypeNegative, 0x0102030405060708, false},
+		{[]byte{0x59, 0x01, 0x00}, TypeBytes, 256, false},
+		{[]byte{0x7f, 0xff}, TypeString, 0, true},
+		{[]byte{0x98, 0x20}, TypeArray, 32, false},
+		{[]byte{0xbf, 0xff}, TypeMap, 0, true},
+		{[]byte{0xd8, 0x25}, TypeTag, 37, false},
+		{[]byte{0xf4}, TypeBool, 20, false},
+		{[]byte{0xf6}, TypeNull, 22, false},
+		{[]byte{0xf7}, TypeUndefined, 23, false},
+		{[]byte{0xf9, 0x3c, 0x00}, TypeFloat, 0x3c00, false},
+		{[]byte{0xff}, TypeBreak, 0, false},
+	}
+
+	for _, tp := range testPatterns {
+		r := NewCBORReader(bytes.NewReader(tp.cbor))
+		if typ, err := r.PeekType(); err != nil || typ != tp.typ {
+			t.Errorf("expected type %v peeking % x but got %v, %v", tp.typ, tp.cbor, typ, err)
+		}
+		typ, u, indef, err := r.PeekHeader()
+		if err != nil || typ != tp.typ || u != tp.u || indef != tp.indef {
+			t.Errorf("unexpected header peeking % x: %v %d %v, %v", tp.cbor, typ, u, indef, err)
+		}
+
+		// nothing has been consumed
+		if raw, err := io.ReadAll(r.Buffered()); err != nil || !bytes.Equal(raw, tp.cbor) {
+			t.Errorf("expected % x to remain after peeking but got % x", tp.cbor, raw)
+		}
+	}
+
+	if _, err := NewCBORReader(bytes.NewReader(nil)).PeekType(); err != io.EOF {
+		t.Errorf("expected io.EOF peeking empty input but got %v", err)
+	}
+	if _, _, _, err := NewCBORReader(bytes.NewReader([]byte{0x1a, 0x01})).PeekHeader(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected unexpected EOF peeking truncated header but got %v", err)
+	}
+	if _, _, _, err := NewCBORReader(bytes.NewReader([]byte{0x1f})).PeekHeader(); !errors.Is(err, InvalidCBORError) {
+		t.Errorf("expected invalid CBOR peeking indefinite integer but got %v", err)
+	}
+}
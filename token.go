@@ -0,0 +1,148 @@
+package borat
+
+import "math"
+
+// Token is a token returned by CBORReader.Token. It is one of ArrayStart,
+// MapStart, TagStart or End, or a complete data item other than an array,
+// map or tag, of the Go type Read would return for it.
+type Token interface{}
+
+// ArrayStart starts an array of the given number of elements, or -1 if the
+// array has indefinite length.
+type ArrayStart int
+
+// MapStart starts a map of the given number of key-value pairs, or -1 if the
+// map has indefinite length.
+type MapStart int
+
+// TagStart is a tag, which applies to the data item in the next token.
+type TagStart CBORTag
+
+// End ends the innermost array or map.
+type End struct{}
+
+// tokenContainer tracks an array or map being walked with Token. Keys and
+// values of a map are counted as separate elements.
+type tokenContainer struct {
+	c     container
+	isMap bool
+	next  int64 // input offset of the element counted off but not yet read
+	done  bool
+	err   error
+}
+
+// Token returns the next token from the CBOR reader. Arrays and maps are
+// returned as an ArrayStart or MapStart, followed by the tokens of their
+// elements and an End, for definite and indefinite lengths alike; the keys
+// and values of a map alternate. Tags are returned as a TagStart followed by
+// the tokens of their content. Strings are returned whole. Only the
+// containers currently open are kept in memory, so arbitrarily large input
+// can be walked, subject to the limits of the reader's DecOptions.
+//
+// An element of an array or map may be read with Read, Unmarshal or Skip
+// instead, as long as More has been called for it. Map keys are not checked
+// for duplicates or order.
+func (r *CBORReader) Token() (Token, error) {
+	if n := len(r.tokens); n > 0 {
+		more, err := r.nextElement()
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			r.tokens = r.tokens[:n-1]
+			r.leave()
+			return End{}, nil
+		}
+	}
+
+	t, err := r.PeekType()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case TypeArray, TypeMap:
+		tc := tokenContainer{isMap: t == TypeMap, next: -1}
+		mt := byte(majorArray)
+		if tc.isMap {
+			mt = majorMap
+		}
+		if tc.c, err = r.readContainer(mt); err != nil {
+			return nil, err
+		}
+		if err := r.enter(); err != nil {
+			return nil, err
+		}
+		n := tc.c.n
+		if tc.isMap {
+			// count keys and values separately
+			if tc.c.n > 0 {
+				tc.c.n *= 2
+			}
+			if tc.c.max < math.MaxInt64/2 {
+				tc.c.max *= 2
+			}
+		}
+		r.tokens = append(r.tokens, tc)
+
+		if tc.isMap {
+			return MapStart(n), nil
+		}
+		return ArrayStart(n), nil
+	case TypeTag:
+		tag, err := r.ReadTag()
+		if err != nil {
+			return nil, err
+		}
+		// the content is the same element of the enclosing container
+		if n := len(r.tokens); n > 0 {
+			r.tokens[n-1].next = r.offset()
+		}
+		return TagStart(tag), nil
+	default:
+		return r.Read()
+	}
+}
+
+// More reports whether another element follows in the array or map being
+// walked with Token, or at the top level, whether there is more input. It
+// counts off the element, which may then be read with Token, Read, Unmarshal
+// or Skip.
+func (r *CBORReader) More() bool {
+	if len(r.tokens) == 0 {
+		t, err := r.PeekType()
+		return err == nil && t != TypeBreak
+	}
+
+	more, err := r.nextElement()
+	return err == nil && more
+}
+
+// nextElement counts off the next element of the innermost container being
+// walked with Token, unless it has already been counted and not yet read, and
+// reports whether there is one.
+func (r *CBORReader) nextElement() (bool, error) {
+	top := &r.tokens[len(r.tokens)-1]
+	if top.done {
+		return false, top.err
+	}
+	if top.next == r.offset() {
+		return true, nil
+	}
+
+	more, err := r.hasMore(&top.c)
+	if err != nil {
+		return false, err
+	}
+	if !more {
+		top.done = true
+		if top.isMap && top.c.count%2 != 0 {
+			// an indefinite-length map ended after a key
+			top.err = r.invalid()
+		}
+		return false, top.err
+	}
+
+	top.next = r.offset()
+	return true, nil
+}
@@ -0,0 +1,97 @@
+package borat
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"gopkg.in/d4l3k/messagediff.v1"
+)
+
+func TestToken(t *testing.T) {
+	// [1, {_ "a": [_ "x"], "b": 1(2)}, null], "z"
+	in := []byte{0x83, 0x01, 0xbf, 0x61, 0x61, 0x9f, 0x61, 0x78, 0xff, 0x61, 0x62, 0xc1, 0x02, 0xff, 0xf6, 0x61, 0x7a}
+	expected := []Token{
+		ArrayStart(3), uint64(1),
+		MapStart(-1), "a", ArrayStart(-1), "x", End{}, "b", TagStart(1), uint64(2), End{},
+		nil, End{},
+		"z",
+	}
+
+	r := NewCBORReader(bytes.NewReader(in))
+	var tokens []Token
+	for {
+		tok, err := r.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to read token after %v: %v", tokens, err)
+		}
+		tokens = append(tokens, tok)
+	}
+	if diff, equal := messagediff.PrettyDiff(tokens, expected); !equal {
+		t.Errorf("unexpected tokens: %v diff=%s", tokens, diff)
+	}
+}
+
+func TestTokenMore(t *testing.T) {
+	type item struct {
+		A int
+	}
+
+	// [{"A": 1}, {"A": 2}] in definite and indefinite form
+	for _, in := range [][]byte{
+		{0x82, 0xa1, 0x61, 0x41, 0x01, 0xa1, 0x61, 0x41, 0x02},
+		{0x9f, 0xa1, 0x61, 0x41, 0x01, 0xa1, 0x61, 0x41, 0x02, 0xff},
+	} {
+		r := NewCBORReader(bytes.NewReader(in))
+		if _, err := r.Token(); err != nil {
+			t.Fatalf("failed to read array start: %v", err)
+		}
+		var items []item
+		for r.More() {
+			var it item
+			if err := r.Unmarshal(&it); err != nil {
+				t.Fatalf("failed to unmarshal element: %v", err)
+			}
+			items = append(items, it)
+		}
+		if tok, err := r.Token(); err != nil || tok != (End{}) {
+			t.Errorf("expected end of array but got %v, %v", tok, err)
+		}
+		if len(items) != 2 || items[0].A != 1 || items[1].A != 2 {
+			t.Errorf("unexpected items read from % x: %v", in, items)
+		}
+		if r.More() {
+			t.Errorf("expected no more input after % x", in)
+		}
+	}
+
+	// {"a": [1], "b": 2}, with keys read as tokens and values unmarshaled
+	r := NewCBORReader(bytes.NewReader([]byte{0xa2, 0x61, 0x61, 0x81, 0x01, 0x61, 0x62, 0x02}))
+	if tok, err := r.Token(); err != nil || tok != MapStart(2) {
+		t.Fatalf("expected map start but got %v, %v", tok, err)
+	}
+	m := make(map[string]interface{})
+	for r.More() {
+		k, err := r.Token()
+		if err != nil || !r.More() {
+			t.Fatalf("failed to read key: %v", err)
+		}
+		if m[k.(string)], err = r.Read(); err != nil {
+			t.Fatalf("failed to read value: %v", err)
+		}
+	}
+	if tok, err := r.Token(); err != nil || tok != (End{}) || len(m) != 2 {
+		t.Errorf("unexpected map %v, end %v, %v", m, tok, err)
+	}
+
+	// an indefinite-length map may not end after a key
+	r = NewCBORReader(bytes.NewReader([]byte{0xbf, 0x61, 0x61, 0xff}))
+	r.Token()
+	r.Token()
+	if _, err := r.Token(); !errors.Is(err, InvalidCBORError) {
+		t.Errorf("expected invalid CBOR for map ending after key but got %v", err)
+	}
+}
@@ -1,8 +1,22 @@
 package borat
 
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
 const (
 	TagDateTimeString = 0
 	TagDateTimeEpoch  = 1
+	TagPosBignum      = 2
+	TagNegBignum      = 3
+	TagDecimal        = 4
+	TagBigFloat       = 5
+	TagRational       = 30
 	TagURI            = 32
 	TagBase64URL      = 33
 	TagBase64         = 34
@@ -11,6 +25,46 @@ const (
 
 type CBORTag uint
 
+// Tag is a tagged data item: a tag number and the content it applies to.
+// CBORReader.Read returns a Tag for tags it does not decode itself, and
+// CBORWriter.Marshal writes the same tag number and content back out. The
+// content is encoded as any other Go value, which is not necessarily as it was
+// read: map keys are read as strings, and floats are written in double
+// precision unless configured otherwise. Use RawMessage to keep a tagged item
+// byte for byte.
+type Tag struct {
+	Number  CBORTag
+	Content interface{}
+}
+
+// MarshalCBOR writes the tag number followed by its content.
+func (t Tag) MarshalCBOR(w *CBORWriter) error {
+	if err := w.WriteTag(t.Number); err != nil {
+		return err
+	}
+	if t.Content == nil {
+		return w.WriteNil()
+	}
+	return w.Marshal(t.Content)
+}
+
+// UnmarshalCBOR reads a tag number and its content, which is decoded as by
+// CBORReader.Read.
+func (t *Tag) UnmarshalCBOR(r *CBORReader) error {
+	n, err := r.ReadTag()
+	if err != nil {
+		return err
+	}
+	v, err := r.Read()
+	if err != nil {
+		return err
+	}
+
+	t.Number = n
+	t.Content = v
+	return nil
+}
+
 const (
 	majorUnsigned = 0x00
 	majorNegative = 0x20
@@ -23,3 +77,148 @@ const (
 	majorMask     = 0x1f
 	majorSelect   = 0xe0
 )
+
+// RawMessage is a complete encoded CBOR data item. It can be used to defer
+// the decoding of part of a message, or to write data already encoded.
+type RawMessage []byte
+
+// MarshalCBOR writes m to the output stream unchanged, after checking that it
+// is a single well-formed data item. A nil RawMessage is written as null.
+func (m RawMessage) MarshalCBOR(w *CBORWriter) error {
+	if m == nil {
+		return w.WriteNil()
+	}
+
+	r := NewCBORReaderWithOptions(bytes.NewReader(m), DecOptions{
+		MaxDepth:    -1,
+		MaxArrayLen: -1,
+		MaxMapLen:   -1,
+	})
+	if _, err := r.ReadRaw(); err == io.EOF {
+		return errors.New("cannot marshal empty RawMessage")
+	} else if err != nil {
+		return err
+	}
+	if _, err := r.readType(); err != io.EOF {
+		return errors.New("RawMessage contains more than one data item")
+	}
+
+	return w.writeEncoded(m)
+}
+
+// UnmarshalCBOR sets *m to a copy of the encoding of the next data item.
+func (m *RawMessage) UnmarshalCBOR(r *CBORReader) error {
+	b, err := r.ReadRaw()
+	if err != nil {
+		return err
+	}
+	*m = b
+	return nil
+}
+
+// CBORType identifies the type of a data item: its major type, refined into
+// floats, booleans, null, undefined and break for major type 7.
+type CBORType int
+
+const (
+	TypeNone CBORType = iota
+	TypeUnsigned
+	TypeNegative
+	TypeBytes
+	TypeString
+	TypeArray
+	TypeMap
+	TypeTag
+	TypeSimple
+	TypeFloat
+	TypeBool
+	TypeNull
+	TypeUndefined
+	TypeBreak
+)
+
+var cborTypeNames = []string{
+	TypeNone:      "none",
+	TypeUnsigned:  "unsigned integer",
+	TypeNegative:  "negative integer",
+	TypeBytes:     "byte string",
+	TypeString:    "text string",
+	TypeArray:     "array",
+	TypeMap:       "map",
+	TypeTag:       "tag",
+	TypeSimple:    "simple value",
+	TypeFloat:     "float",
+	TypeBool:      "bool",
+	TypeNull:      "null",
+	TypeUndefined: "undefined",
+	TypeBreak:     "break",
+}
+
+func (t CBORType) String() string {
+	if t < 0 || int(t) >= len(cborTypeNames) {
+		return "CBORType(" + strconv.Itoa(int(t)) + ")"
+	}
+	return cborTypeNames[t]
+}
+
+// typeOf returns the type of the data item with the initial byte ct.
+func typeOf(ct byte) CBORType {
+	switch ct & majorSelect {
+	case majorUnsigned:
+		return TypeUnsigned
+	case majorNegative:
+		return TypeNegative
+	case majorBytes:
+		return TypeBytes
+	case majorString:
+		return TypeString
+	case majorArray:
+		return TypeArray
+	case majorMap:
+		return TypeMap
+	case majorTag:
+		return TypeTag
+	}
+
+	switch ct {
+	case 0xf4, 0xf5:
+		return TypeBool
+	case 0xf6:
+		return TypeNull
+	case 0xf7:
+		return TypeUndefined
+	case 0xf9, 0xfa, 0xfb:
+		return TypeFloat
+	case 0xff:
+		return TypeBreak
+	}
+	return TypeSimple
+}
+
+// InvalidUTF8Error reports that a text string is not valid UTF-8. A
+// CBORReader returns it wrapped in a DecodeError whose offset is that of the
+// invalid byte in the input.
+type InvalidUTF8Error struct {
+	// Offset is the offset of the first invalid byte within the string.
+	Offset int
+}
+
+func (e *InvalidUTF8Error) Error() string {
+	return fmt.Sprintf("invalid UTF-8 in text string at offset %d", e.Offset)
+}
+
+// invalidUTF8 returns the offset of the first byte of b which is not part of
+// a valid UTF-8 encoding, or -1 if b is valid UTF-8.
+func invalidUTF8(b []byte) int {
+	if utf8.Valid(b) {
+		return -1
+	}
+	for i := 0; i < len(b); {
+		c, n := utf8.DecodeRune(b[i:])
+		if c == utf8.RuneError && n == 1 {
+			return i
+		}
+		i += n
+	}
+	return -1
+}
@@ -1,10 +1,13 @@
 package borat
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/big"
 	"reflect"
 	"sort"
 	"time"
@@ -18,43 +21,200 @@ const (
 	DateTimePrefString
 )
 
+// FloatPref selects how floating point numbers are encoded.
+type FloatPref int
+
+const (
+	// FloatPrefDouble writes every floating point number in double precision.
+	FloatPrefDouble FloatPref = iota
+	// FloatPrefShortest writes each floating point number in the shortest of
+	// half, single and double precision that represents it exactly. NaN is
+	// always written as the half-precision quiet NaN 0xf97e00.
+	FloatPrefShortest
+)
+
+// Profile selects a deterministic encoding, which fixes the order of the keys
+// of every map written, including maps written for structs. Every profile
+// writes integers, lengths and tags with the shortest header, and forbids
+// indefinite lengths.
+type Profile int
+
+const (
+	// ProfileNone sorts string keys lexically and integer keys numerically,
+	// which matches none of the standard profiles.
+	ProfileNone Profile = iota
+	// ProfileRFC7049 is the canonical CBOR of RFC 7049 section 3.9: shorter
+	// encoded keys sort first, and keys of the same length sort bytewise.
+	// Floating point numbers are written in shortest form.
+	ProfileRFC7049
+	// ProfileCore is the core deterministic encoding of RFC 8949 section
+	// 4.2.1: keys sort in the bytewise order of their encodings. Floating
+	// point numbers are written in shortest form. This is the encoding
+	// checked by DecOptions.Strict.
+	ProfileCore
+	// ProfileCTAP2 is the canonical CBOR of the FIDO CTAP2 specification:
+	// keys sort by major type, then by encoded length, then bytewise. Tags
+	// may not be written.
+	ProfileCTAP2
+)
+
+// less reports whether the encoded map key a sorts before b.
+func (p Profile) less(a, b []byte) bool {
+	switch p {
+	case ProfileRFC7049:
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+	case ProfileCTAP2:
+		if a[0]&majorSelect != b[0]&majorSelect {
+			return a[0]&majorSelect < b[0]&majorSelect
+		}
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+	}
+	return bytes.Compare(a, b) < 0
+}
+
+// EncOptions configures how a CBORWriter encodes values.
+type EncOptions struct {
+	Float   FloatPref
+	Profile Profile
+	// AllowInvalidUTF8 writes strings which are not valid UTF-8 as text
+	// strings, instead of failing with an InvalidUTF8Error.
+	AllowInvalidUTF8 bool
+}
+
 // CBORWriter writes CBOR to an output stream. It provides a relatively
 // low-level interface, allowing the caller to write typed data to the stream as
 // CBOR, as well as a higher-level Marshal interface which uses reflection to
 // properly encode arbitrary objects as CBOR.
 type CBORWriter struct {
 	dateTimePref DateTimePref
+	opts         EncOptions
 	out          io.Writer
 	scsCache     map[reflect.Type]*structCBORSpec
+	open         []openItem
+}
+
+// openItem tracks a container or tag whose contents are still being written.
+// Items are only tracked while an indefinite-length item is open.
+type openItem struct {
+	mt        byte
+	indef     bool
+	remaining uint64 // items still to come in a definite-length item
+	count     uint64 // items written so far to an indefinite-length item
 }
 
 // NewCBORWriter creates a new CBORWriter around a given output stream
 // (io.Writer).
 func NewCBORWriter(out io.Writer) *CBORWriter {
+	return NewCBORWriterWithOptions(out, EncOptions{})
+}
+
+// NewCBORWriterWithOptions creates a new CBORWriter around a given output
+// stream, encoding values as configured by opts.
+func NewCBORWriterWithOptions(out io.Writer, opts EncOptions) *CBORWriter {
 	w := &CBORWriter{
 		dateTimePref: DateTimePrefInt,
+		opts:         opts,
 		out:          out,
 		scsCache:     make(map[reflect.Type]*structCBORSpec),
 	}
 	return w
 }
 
-func (w *CBORWriter) writeBasicInt(u uint, mt byte) error {
+// startItem accounts for the header of a new data item of major type mt with
+// argument u in the innermost open item, and fails without writing anything
+// if the item may not appear there.
+func (w *CBORWriter) startItem(mt byte, u uint64, indef bool) error {
+	if len(w.open) == 0 && !indef {
+		return nil
+	}
+	if err := w.countItem(mt, indef); err != nil {
+		return err
+	}
+
+	// containers and tags stay open until their contents have been written
+	var children uint64
+	switch mt {
+	case majorArray:
+		children = u
+	case majorMap:
+		children = 2 * u
+	case majorTag:
+		children = 1
+	}
+	if indef || children > 0 {
+		w.open = append(w.open, openItem{mt: mt, indef: indef, remaining: children})
+		return nil
+	}
+
+	w.endItem()
+	return nil
+}
+
+// countItem accounts for a new data item of major type mt in the innermost
+// open item, and fails if the item may not appear there.
+func (w *CBORWriter) countItem(mt byte, indef bool) error {
+	if len(w.open) == 0 {
+		return nil
+	}
+
+	top := &w.open[len(w.open)-1]
+	if top.indef && (top.mt == majorBytes || top.mt == majorString) && (mt != top.mt || indef) {
+		return errors.New("indefinite-length string may only contain definite-length strings of the same type")
+	}
+	if top.indef {
+		top.count++
+	} else {
+		top.remaining--
+	}
+	return nil
+}
+
+// writeEncoded writes the complete encoded data item b to the output stream.
+func (w *CBORWriter) writeEncoded(b []byte) error {
+	if err := w.countItem(b[0]&majorSelect, b[0]&majorMask == 31); err != nil {
+		return err
+	}
+	w.endItem()
+
+	_, err := w.out.Write(b)
+	return err
+}
+
+// endItem closes any definite-length items completed by the item just written.
+func (w *CBORWriter) endItem() {
+	for len(w.open) > 0 {
+		top := w.open[len(w.open)-1]
+		if top.indef || top.remaining > 0 {
+			return
+		}
+		w.open = w.open[:len(w.open)-1]
+	}
+}
+
+func (w *CBORWriter) writeBasicInt(u uint64, mt byte) error {
+	if err := w.startItem(mt, u, false); err != nil {
+		return err
+	}
+
 	var out []byte
 
 	if u < 24 {
 		out = []byte{mt | byte(u)}
-	} else if u < math.MaxUint8 {
+	} else if u <= math.MaxUint8 {
 		out = []byte{mt | 24, byte(u)}
-	} else if u < math.MaxUint16 {
+	} else if u <= math.MaxUint16 {
 		out = []byte{mt | 25, 0, 0}
 		binary.BigEndian.PutUint16(out[1:3], uint16(u))
-	} else if u < math.MaxUint32 {
+	} else if u <= math.MaxUint32 {
 		out = []byte{mt | 26, 0, 0, 0, 0}
 		binary.BigEndian.PutUint32(out[1:5], uint32(u))
 	} else {
 		out = []byte{mt | 27, 0, 0, 0, 0, 0, 0, 0, 0}
-		binary.BigEndian.PutUint64(out[1:9], uint64(u))
+		binary.BigEndian.PutUint64(out[1:9], u)
 	}
 
 	_, err := w.out.Write(out)
@@ -63,36 +223,114 @@ func (w *CBORWriter) writeBasicInt(u uint, mt byte) error {
 
 // WriteTag writes a CBOR tag to the output stream. CBOR tags are used to note the semantics of the following object.
 func (w *CBORWriter) WriteTag(t CBORTag) error {
-	return w.writeBasicInt(uint(t), majorTag)
+	if w.opts.Profile == ProfileCTAP2 {
+		return errors.New("tags may not be written in the CTAP2 profile")
+	}
+	return w.writeBasicInt(uint64(t), majorTag)
 }
 
 // WriteInt writes an integer to the output stream.
 func (w *CBORWriter) WriteInt(i int) error {
-	var u uint
+	return w.writeInt64(int64(i))
+}
+
+func (w *CBORWriter) writeInt64(i int64) error {
+	var u uint64
 	var mt byte
 	if i >= 0 {
-		u = uint(i)
+		u = uint64(i)
 		mt = majorUnsigned
 	} else {
-		u = uint(-1 - i)
+		u = uint64(-1 - i)
 		mt = majorNegative
 	}
 
 	return w.writeBasicInt(u, mt)
 }
 
+// WriteUint writes an unsigned integer to the output stream, covering the
+// whole range of uint64.
+func (w *CBORWriter) WriteUint(u uint64) error {
+	return w.writeBasicInt(u, majorUnsigned)
+}
+
 // WriteFloat writes a floating point number to the output stream.
 func (w *CBORWriter) WriteFloat(f float64) error {
-	out := []byte{majorOther | 27, 0, 0, 0, 0, 0, 0, 0, 0}
-	u := math.Float64bits(f)
-	binary.BigEndian.PutUint64(out[1:9], u)
+	if err := w.startItem(majorOther, 0, false); err != nil {
+		return err
+	}
+
+	var out []byte
+	if w.opts.Float == FloatPrefShortest || w.opts.Profile == ProfileRFC7049 || w.opts.Profile == ProfileCore {
+		out = shortestFloat(f)
+	} else {
+		out = []byte{majorOther | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(out[1:9], math.Float64bits(f))
+	}
 
 	_, err := w.out.Write(out)
 	return err
 }
 
+// shortestFloat encodes f in the shortest of half, single and double precision
+// that represents it exactly.
+func shortestFloat(f float64) []byte {
+	if math.IsNaN(f) {
+		return []byte{majorOther | 25, 0x7e, 0x00}
+	}
+
+	if f32 := float32(f); float64(f32) == f {
+		if h, ok := float16Bits(f32); ok {
+			out := []byte{majorOther | 25, 0, 0}
+			binary.BigEndian.PutUint16(out[1:3], h)
+			return out
+		}
+		out := []byte{majorOther | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(out[1:5], math.Float32bits(f32))
+		return out
+	}
+
+	out := []byte{majorOther | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(out[1:9], math.Float64bits(f))
+	return out
+}
+
+// float16Bits returns the IEEE 754 half-precision encoding of f, if f can be
+// represented exactly in half precision. f must not be NaN.
+func float16Bits(f float32) (uint16, bool) {
+	u := math.Float32bits(f)
+	sign := uint16(u>>16) & 0x8000
+	exp := int(u>>23&0xff) - 127
+	mant := u & 0x7fffff
+
+	switch {
+	case u&0x7fffffff == 0:
+		// signed zero
+		return sign, true
+	case exp == 128:
+		// infinity
+		return sign | 0x7c00, true
+	case exp >= -14 && exp <= 15:
+		// normal: the low 13 bits of the mantissa are lost
+		if mant&0x1fff != 0 {
+			return 0, false
+		}
+		return sign | uint16(exp+15)<<10 | uint16(mant>>13), true
+	case exp >= -24 && exp < -14:
+		// subnormal: the value is a multiple of 2^-24
+		full := mant | 0x800000
+		shift := uint(-exp - 1)
+		if full&(1<<shift-1) != 0 {
+			return 0, false
+		}
+		return sign | uint16(full>>shift), true
+	}
+
+	return 0, false
+}
+
 func (w *CBORWriter) writeBasicBytes(b []byte, mt byte) error {
-	if err := w.writeBasicInt(uint(len(b)), mt); err != nil {
+	if err := w.writeBasicInt(uint64(len(b)), mt); err != nil {
 		return err
 	}
 
@@ -105,13 +343,24 @@ func (w *CBORWriter) WriteBytes(b []byte) error {
 	return w.writeBasicBytes(b, majorBytes)
 }
 
-// WriteString writes a string to the output stream.
+// WriteString writes a string to the output stream. Unless the writer's
+// options allow it, the string must be valid UTF-8.
 func (w *CBORWriter) WriteString(s string) error {
-	return w.writeBasicBytes([]byte(s), majorString)
+	b := []byte(s)
+	if !w.opts.AllowInvalidUTF8 {
+		if i := invalidUTF8(b); i >= 0 {
+			return &InvalidUTF8Error{Offset: i}
+		}
+	}
+	return w.writeBasicBytes(b, majorString)
 }
 
 // WriteBool writes a boolean value to the output stream.
 func (w *CBORWriter) WriteBool(b bool) error {
+	if err := w.startItem(majorOther, 0, false); err != nil {
+		return err
+	}
+
 	out := []byte{0xf4}
 	if b {
 		out[0] = 0xf5
@@ -139,6 +388,10 @@ func (w *CBORWriter) WriteTime(t time.Time) error {
 
 // WriteNil writes a nil to the output stream
 func (w *CBORWriter) WriteNil() error {
+	if err := w.startItem(majorOther, 0, false); err != nil {
+		return err
+	}
+
 	out := []byte{0xf6}
 	_, err := w.out.Write(out)
 	return err
@@ -147,7 +400,7 @@ func (w *CBORWriter) WriteNil() error {
 // WriteArray writes an arbitrary slice to the output stream. Each of the
 // elements of the array will be reflected and written as appropriate.
 func (w *CBORWriter) WriteArray(a []interface{}) error {
-	if err := w.writeBasicInt(uint(len(a)), majorArray); err != nil {
+	if err := w.writeBasicInt(uint64(len(a)), majorArray); err != nil {
 		return err
 	}
 
@@ -160,9 +413,72 @@ func (w *CBORWriter) WriteArray(a []interface{}) error {
 	return nil
 }
 
+// BeginArray starts an indefinite-length array on the output stream. Every
+// data item written after it becomes an element of the array, until the
+// array is closed with End.
+func (w *CBORWriter) BeginArray() error {
+	return w.begin(majorArray)
+}
+
+// BeginMap starts an indefinite-length map on the output stream. Data items
+// written after it are alternately keys and values, until the map is closed
+// with End.
+func (w *CBORWriter) BeginMap() error {
+	return w.begin(majorMap)
+}
+
+// BeginBytes starts an indefinite-length byte string on the output stream.
+// Until it is closed with End, only WriteBytes may be called, and each call
+// writes one chunk of the string.
+func (w *CBORWriter) BeginBytes() error {
+	return w.begin(majorBytes)
+}
+
+// BeginString starts an indefinite-length text string on the output stream.
+// Until it is closed with End, only WriteString may be called, and each call
+// writes one chunk of the string.
+func (w *CBORWriter) BeginString() error {
+	return w.begin(majorString)
+}
+
+func (w *CBORWriter) begin(mt byte) error {
+	if w.opts.Profile != ProfileNone {
+		return errors.New("indefinite-length items may not be written in a deterministic encoding profile")
+	}
+	if err := w.startItem(mt, 0, true); err != nil {
+		return err
+	}
+
+	_, err := w.out.Write([]byte{mt | 31})
+	return err
+}
+
+// End closes the innermost item opened by BeginArray, BeginMap, BeginBytes or
+// BeginString by writing a break stop code to the output stream.
+func (w *CBORWriter) End() error {
+	if len(w.open) == 0 {
+		return errors.New("End called without an open indefinite-length item")
+	}
+
+	top := w.open[len(w.open)-1]
+	if !top.indef {
+		return errors.New("End called before the enclosing definite-length item was complete")
+	}
+	if top.mt == majorMap && top.count%2 != 0 {
+		return errors.New("End called on a map with a key but no value")
+	}
+
+	if _, err := w.out.Write([]byte{0xff}); err != nil {
+		return err
+	}
+	w.open = w.open[:len(w.open)-1]
+	w.endItem()
+	return nil
+}
+
 // WriteStringArray writes a slice of strings to the output stream.
 func (w *CBORWriter) WriteStringArray(a []string) error {
-	if err := w.writeBasicInt(uint(len(a)), majorArray); err != nil {
+	if err := w.writeBasicInt(uint64(len(a)), majorArray); err != nil {
 		return err
 	}
 
@@ -177,7 +493,7 @@ func (w *CBORWriter) WriteStringArray(a []string) error {
 
 // WriteIntArray writes a slice of integers to the output stream.
 func (w *CBORWriter) WriteIntArray(a []int) error {
-	if err := w.writeBasicInt(uint(len(a)), majorArray); err != nil {
+	if err := w.writeBasicInt(uint64(len(a)), majorArray); err != nil {
 		return err
 	}
 
@@ -194,10 +510,20 @@ func (w *CBORWriter) WriteIntArray(a []int) error {
 // stream. Each of the values of the map will be reflected and written as
 // appropriate.
 func (w *CBORWriter) WriteStringMap(m map[string]interface{}) error {
-	if err := w.writeBasicInt(uint(len(m)), majorMap); err != nil {
+	if err := w.writeBasicInt(uint64(len(m)), majorMap); err != nil {
 		return err
 	}
 
+	if w.opts.Profile != ProfileNone {
+		keys := make([]interface{}, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		return w.writeSortedEntries(keys, func(i int) error {
+			return w.Marshal(m[keys[i].(string)])
+		})
+	}
+
 	// get sorted keys array
 	keys := make([]string, len(m))
 	i := 0
@@ -224,10 +550,20 @@ func (w *CBORWriter) WriteStringMap(m map[string]interface{}) error {
 // stream. Each of the values of the map will be reflected and written as
 // appropriate.
 func (w *CBORWriter) WriteIntMap(m map[int]interface{}) error {
-	if err := w.writeBasicInt(uint(len(m)), majorMap); err != nil {
+	if err := w.writeBasicInt(uint64(len(m)), majorMap); err != nil {
 		return err
 	}
 
+	if w.opts.Profile != ProfileNone {
+		keys := make([]interface{}, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		return w.writeSortedEntries(keys, func(i int) error {
+			return w.Marshal(m[keys[i].(int)])
+		})
+	}
+
 	// get sorted keys array
 	keys := make([]int, len(m))
 	i := 0
@@ -250,6 +586,115 @@ func (w *CBORWriter) WriteIntMap(m map[int]interface{}) error {
 	return nil
 }
 
+// writeSortedEntries writes the entries of a map whose header has been
+// written, with the keys in the order of the encoding profile, or without a
+// profile, as legacyLess orders them. value is called to write the value for
+// the key keys[i].
+func (w *CBORWriter) writeSortedEntries(keys []interface{}, value func(i int) error) error {
+	// keys are sorted by their encodings, so encode them first
+	enc := make([][]byte, len(keys))
+	for i, k := range keys {
+		var buf bytes.Buffer
+		if err := NewCBORWriterWithOptions(&buf, w.opts).Marshal(k); err != nil {
+			return err
+		}
+		enc[i] = buf.Bytes()
+	}
+
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if w.opts.Profile == ProfileNone {
+			return legacyLess(keys[i], keys[j], enc[i], enc[j])
+		}
+		return w.opts.Profile.less(enc[i], enc[j])
+	})
+
+	for _, i := range order {
+		if err := w.writeEncoded(enc[i]); err != nil {
+			return err
+		}
+		if err := value(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// legacyLess reports whether the map key a, encoded as ea, sorts before b,
+// encoded as eb, in the order used without a profile: strings lexically and
+// integers numerically, as WriteStringMap and WriteIntMap sort them. Other
+// keys sort by their encodings, after strings and integers.
+func legacyLess(a, b interface{}, ea, eb []byte) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	ra, rb := keyRank(va), keyRank(vb)
+	if ra != rb {
+		return ra < rb
+	}
+
+	switch ra {
+	case keyRankString:
+		return va.String() < vb.String()
+	case keyRankInt:
+		// negative integers sort before all unsigned ones
+		if isUnsigned(va) != isUnsigned(vb) {
+			return isUnsigned(vb)
+		}
+		if isUnsigned(va) {
+			return uintValue(va) < uintValue(vb)
+		}
+		return va.Int() < vb.Int()
+	default:
+		return bytes.Compare(ea, eb) < 0
+	}
+}
+
+// Ranks of map keys for legacyLess.
+const (
+	keyRankString = iota
+	keyRankInt
+	keyRankOther
+)
+
+// keyRank groups map keys for legacyLess: strings, then integers of any kind,
+// then everything else.
+func keyRank(v reflect.Value) int {
+	switch v.Kind() {
+	case reflect.String:
+		return keyRankString
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return keyRankInt
+	default:
+		return keyRankOther
+	}
+}
+
+// isUnsigned reports whether the integer v is of an unsigned kind, or a
+// signed kind with a non-negative value.
+func isUnsigned(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() >= 0
+	default:
+		return true
+	}
+}
+
+// uintValue returns the value of the integer v, for which isUnsigned is true.
+func uintValue(v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int())
+	default:
+		return v.Uint()
+	}
+}
+
 // Marshal marshals an arbitrary object to the output stream using reflection.
 // If the object is a primitive type, it will be marshaled as such. If it
 // implements CBORMarshaler, its MarshalCBOR function will be called. If the
@@ -258,39 +703,71 @@ func (w *CBORWriter) WriteIntMap(m map[int]interface{}) error {
 // marshaled as a map of strings to objects using the names of the public
 // members of the struct.
 func (w *CBORWriter) Marshal(x interface{}) error {
-
 	v := reflect.ValueOf(x)
 
+	// nil, and nil pointers, slices and maps, are written as null
+	if !v.IsValid() {
+		return w.WriteNil()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		if v.IsNil() {
+			return w.WriteNil()
+		}
+	}
+
+	// numeric types from math/big have their own encodings
+	switch n := x.(type) {
+	case *big.Int:
+		return w.WriteBigInt(n)
+	case big.Int:
+		return w.WriteBigInt(&n)
+	case *big.Rat:
+		return w.WriteRat(n)
+	case big.Rat:
+		return w.WriteRat(&n)
+	}
+
 	// if the type implements marshaler, just do that
 	if v.Type().Implements(reflect.TypeOf((*CBORMarshaler)(nil)).Elem()) {
 		return v.Interface().(CBORMarshaler).MarshalCBOR(w)
 	}
 
 	switch v.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		return w.WriteInt(int(v.Int()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return w.writeInt64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return w.WriteUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return w.WriteFloat(v.Float())
 	case reflect.Bool:
 		return w.WriteBool(v.Bool())
 	case reflect.String:
 		return w.WriteString(v.String())
-	case reflect.Slice:
-		// treat byte slices specially
-		switch v.Type() {
-		case reflect.TypeOf([]byte{}):
-			return w.WriteBytes(v.Bytes())
-		case reflect.TypeOf([]string{}):
-			return w.WriteStringArray(v.Interface().([]string))
-		case reflect.TypeOf([]int{}):
-			return w.WriteIntArray(v.Interface().([]int))
-		default:
-			return w.WriteArray(v.Interface().([]interface{}))
-		}
-	case reflect.Array:
-		s := make([]interface{}, v.Len())
-		for i := 0; i < v.Len(); i++ {
-			s[i] = v.Elem().Interface()
+	case reflect.Slice, reflect.Array:
+		// treat byte slices and arrays specially
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Type().Elem() == byteType {
+				if v.Kind() == reflect.Slice || v.CanAddr() {
+					return w.WriteBytes(v.Bytes())
+				}
+				b := make([]byte, v.Len())
+				reflect.Copy(reflect.ValueOf(b), v)
+				return w.WriteBytes(b)
+			}
+			// named byte types can't be copied into a []byte
+			b := make([]byte, v.Len())
+			for i := range b {
+				b[i] = byte(v.Index(i).Uint())
+			}
+			return w.WriteBytes(b)
 		}
-		return w.WriteArray(s)
+		return w.writeReflectedArray(v)
+	case reflect.Map:
+		return w.writeReflectedMap(v)
+	case reflect.Ptr:
+		// pointers are written as the value they point to
+		return w.Marshal(v.Elem().Interface())
 	case reflect.Struct:
 		// treat times sepcially
 		if v.Type() == reflect.TypeOf(time.Time{}) {
@@ -303,6 +780,41 @@ func (w *CBORWriter) Marshal(x interface{}) error {
 	}
 }
 
+var byteType = reflect.TypeOf(byte(0))
+
+// writeReflectedArray writes a slice or array of any type, marshaling each of
+// its elements.
+func (w *CBORWriter) writeReflectedArray(v reflect.Value) error {
+	if err := w.writeBasicInt(uint64(v.Len()), majorArray); err != nil {
+		return err
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		if err := w.Marshal(v.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeReflectedMap writes a map of any type, marshaling each of its keys and
+// values, with the keys sorted as by WriteStringMap and WriteIntMap.
+func (w *CBORWriter) writeReflectedMap(v reflect.Value) error {
+	if err := w.writeBasicInt(uint64(v.Len()), majorMap); err != nil {
+		return err
+	}
+
+	mk := v.MapKeys()
+	keys := make([]interface{}, len(mk))
+	for i, k := range mk {
+		keys[i] = k.Interface()
+	}
+	return w.writeSortedEntries(keys, func(i int) error {
+		return w.Marshal(v.MapIndex(mk[i]).Interface())
+	})
+}
+
 func (w *CBORWriter) writeReflectedStruct(v reflect.Value) error {
 	// retrieve or cache structure specification
 	var scs *structCBORSpec
@@ -313,6 +825,13 @@ func (w *CBORWriter) writeReflectedStruct(v reflect.Value) error {
 		w.scsCache[v.Type()] = scs
 	}
 
+	// write the struct's tag, if it declares one
+	if scs.hasTag {
+		if err := w.WriteTag(CBORTag(scs.tag)); err != nil {
+			return err
+		}
+	}
+
 	// and write either an int map or a string map
 	if scs.usingIntKeys() {
 		return w.WriteIntMap(scs.convertStructToIntMap(v))
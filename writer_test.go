@@ -2,6 +2,10 @@ package borat_test
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"math"
+	"runtime"
 	"testing"
 	"time"
 
@@ -28,6 +32,10 @@ func TestWriteIntegers(t *testing.T) {
 		{1, []byte{0x01}},
 		{-1, []byte{0x20}},
 		{33, []byte{0x18, 0x21}},
+		{255, []byte{0x18, 0xff}},
+		{65535, []byte{0x19, 0xff, 0xff}},
+		{-256, []byte{0x38, 0xff}},
+		{4294967295, []byte{0x1a, 0xff, 0xff, 0xff, 0xff}},
 		{444, []byte{0x19, 0x01, 0xbc}},
 		{-6666, []byte{0x39, 0x1a, 0x09}},
 		{99999, []byte{0x1a, 0x00, 0x01, 0x86, 0x9f}},
@@ -256,3 +264,333 @@ func TestWriteStructs(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteIndefinite(t *testing.T) {
+	testPatterns := []struct {
+		write func(w *borat.CBORWriter) error
+		cbor  []byte
+	}{
+		{
+			func(w *borat.CBORWriter) error {
+				w.BeginArray()
+				w.WriteInt(1)
+				w.WriteArray([]interface{}{2, 3})
+				w.BeginArray()
+				w.WriteInt(4)
+				w.WriteInt(5)
+				w.End()
+				return w.End()
+			},
+			[]byte{0x9f, 0x01, 0x82, 0x02, 0x03, 0x9f, 0x04, 0x05, 0xff, 0xff},
+		},
+		{
+			func(w *borat.CBORWriter) error {
+				w.BeginMap()
+				w.WriteString("a")
+				w.WriteInt(1)
+				w.WriteString("b")
+				w.BeginArray()
+				w.WriteInt(2)
+				w.WriteInt(3)
+				w.End()
+				return w.End()
+			},
+			[]byte{0xbf, 0x61, 0x61, 0x01, 0x61, 0x62, 0x9f, 0x02, 0x03, 0xff, 0xff},
+		},
+		{
+			func(w *borat.CBORWriter) error {
+				w.BeginBytes()
+				w.WriteBytes([]byte{0x01, 0x02})
+				w.WriteBytes([]byte{0x03, 0x04, 0x05})
+				return w.End()
+			},
+			[]byte{0x5f, 0x42, 0x01, 0x02, 0x43, 0x03, 0x04, 0x05, 0xff},
+		},
+		{
+			func(w *borat.CBORWriter) error {
+				w.BeginString()
+				w.WriteString("strea")
+				w.WriteString("ming")
+				return w.End()
+			},
+			[]byte{0x7f, 0x65, 0x73, 0x74, 0x72, 0x65, 0x61, 0x64, 0x6d, 0x69, 0x6e, 0x67, 0xff},
+		},
+	}
+
+	for i := range testPatterns {
+		var buf bytes.Buffer
+		if err := testPatterns[i].write(borat.NewCBORWriter(&buf)); err != nil {
+			t.Errorf("unexpected error writing pattern %d: %v", i, err)
+		}
+		if bytes.Compare(buf.Bytes(), testPatterns[i].cbor) != 0 {
+			t.Errorf("error writing pattern %d: expected [% X], got [% X]",
+				i, testPatterns[i].cbor, buf.Bytes())
+		}
+	}
+}
+
+func TestWriteIndefiniteMisuse(t *testing.T) {
+	testPatterns := []struct {
+		write func(w *borat.CBORWriter) error
+		cbor  []byte
+	}{
+		{
+			func(w *borat.CBORWriter) error {
+				return w.End()
+			},
+			[]byte{},
+		},
+		{
+			func(w *borat.CBORWriter) error {
+				w.BeginMap()
+				w.WriteString("a")
+				return w.End()
+			},
+			[]byte{0xbf, 0x61, 0x61},
+		},
+		{
+			func(w *borat.CBORWriter) error {
+				w.BeginString()
+				return w.WriteInt(1)
+			},
+			[]byte{0x7f},
+		},
+		{
+			func(w *borat.CBORWriter) error {
+				w.BeginBytes()
+				return w.BeginBytes()
+			},
+			[]byte{0x5f},
+		},
+	}
+
+	for i := range testPatterns {
+		var buf bytes.Buffer
+		if err := testPatterns[i].write(borat.NewCBORWriter(&buf)); err == nil {
+			t.Errorf("expected error writing pattern %d", i)
+		}
+		if bytes.Compare(buf.Bytes(), testPatterns[i].cbor) != 0 {
+			t.Errorf("error writing pattern %d: expected [% X], got [% X]",
+				i, testPatterns[i].cbor, buf.Bytes())
+		}
+	}
+}
+
+func TestWriteFloats(t *testing.T) {
+	testPatterns := []struct {
+		value    float64
+		shortest []byte
+		double   []byte
+	}{
+		{0.0, []byte{0xf9, 0x00, 0x00}, []byte{0xfb, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{math.Copysign(0, -1), []byte{0xf9, 0x80, 0x00}, []byte{0xfb, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{1.0, []byte{0xf9, 0x3c, 0x00}, []byte{0xfb, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}},
+		{1.1, []byte{0xfb, 0x3f, 0xf1, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}, []byte{0xfb, 0x3f, 0xf1, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+		{1.5, []byte{0xf9, 0x3e, 0x00}, []byte{0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{65504.0, []byte{0xf9, 0x7b, 0xff}, []byte{0xfb, 0x40, 0xef, 0xfc, 0, 0, 0, 0, 0}},
+		{65505.0, []byte{0xfa, 0x47, 0x7f, 0xe1, 0x00}, []byte{0xfb, 0x40, 0xef, 0xfc, 0x20, 0, 0, 0, 0}},
+		{100000.0, []byte{0xfa, 0x47, 0xc3, 0x50, 0x00}, []byte{0xfb, 0x40, 0xf8, 0x6a, 0, 0, 0, 0, 0}},
+		{3.4028234663852886e+38, []byte{0xfa, 0x7f, 0x7f, 0xff, 0xff}, []byte{0xfb, 0x47, 0xef, 0xff, 0xff, 0xe0, 0, 0, 0}},
+		{1.0e+300, []byte{0xfb, 0x7e, 0x37, 0xe4, 0x3c, 0x88, 0x00, 0x75, 0x9c}, []byte{0xfb, 0x7e, 0x37, 0xe4, 0x3c, 0x88, 0x00, 0x75, 0x9c}},
+		{5.960464477539063e-08, []byte{0xf9, 0x00, 0x01}, []byte{0xfb, 0x3e, 0x70, 0, 0, 0, 0, 0, 0}},
+		{0.00006103515625, []byte{0xf9, 0x04, 0x00}, []byte{0xfb, 0x3f, 0x10, 0, 0, 0, 0, 0, 0}},
+		{-4.0, []byte{0xf9, 0xc4, 0x00}, []byte{0xfb, 0xc0, 0x10, 0, 0, 0, 0, 0, 0}},
+		{math.Inf(1), []byte{0xf9, 0x7c, 0x00}, []byte{0xfb, 0x7f, 0xf0, 0, 0, 0, 0, 0, 0}},
+		{math.Inf(-1), []byte{0xf9, 0xfc, 0x00}, []byte{0xfb, 0xff, 0xf0, 0, 0, 0, 0, 0, 0}},
+		{math.NaN(), []byte{0xf9, 0x7e, 0x00}, []byte{0xfb, 0x7f, 0xf8, 0, 0, 0, 0, 0, 1}},
+	}
+
+	for i := range testPatterns {
+		shortest := func(in interface{}, out *bytes.Buffer) {
+			w := borat.NewCBORWriterWithOptions(out, borat.EncOptions{Float: borat.FloatPrefShortest})
+			w.WriteFloat(in.(float64))
+		}
+		cborTestHarness(t, testPatterns[i].value, testPatterns[i].shortest, shortest)
+		if !math.IsNaN(testPatterns[i].value) {
+			double := func(in interface{}, out *bytes.Buffer) {
+				w := borat.NewCBORWriter(out)
+				w.WriteFloat(in.(float64))
+			}
+			cborTestHarness(t, testPatterns[i].value, testPatterns[i].double, double)
+		}
+	}
+}
+
+func TestWriteProfiles(t *testing.T) {
+	intMap := map[int]interface{}{-1: 0, 24: 0, 1: 0}
+	type keyed struct {
+		AA int `cbor:"aa"`
+		B  int `cbor:"b"`
+	}
+
+	testPatterns := []struct {
+		profile borat.Profile
+		ints    []byte
+		strings []byte
+	}{
+		{borat.ProfileNone,
+			[]byte{0xa3, 0x20, 0x00, 0x01, 0x00, 0x18, 0x18, 0x00},
+			[]byte{0xa2, 0x62, 0x61, 0x61, 0x00, 0x61, 0x62, 0x00}},
+		{borat.ProfileRFC7049,
+			[]byte{0xa3, 0x01, 0x00, 0x20, 0x00, 0x18, 0x18, 0x00},
+			[]byte{0xa2, 0x61, 0x62, 0x00, 0x62, 0x61, 0x61, 0x00}},
+		{borat.ProfileCore,
+			[]byte{0xa3, 0x01, 0x00, 0x18, 0x18, 0x00, 0x20, 0x00},
+			[]byte{0xa2, 0x61, 0x62, 0x00, 0x62, 0x61, 0x61, 0x00}},
+		{borat.ProfileCTAP2,
+			[]byte{0xa3, 0x01, 0x00, 0x18, 0x18, 0x00, 0x20, 0x00},
+			[]byte{0xa2, 0x61, 0x62, 0x00, 0x62, 0x61, 0x61, 0x00}},
+	}
+
+	for _, tp := range testPatterns {
+		opts := borat.EncOptions{Profile: tp.profile}
+		cborTestHarness(t, intMap, tp.ints, func(in interface{}, out *bytes.Buffer) {
+			borat.NewCBORWriterWithOptions(out, opts).WriteIntMap(in.(map[int]interface{}))
+		})
+		cborTestHarness(t, keyed{}, tp.strings, func(in interface{}, out *bytes.Buffer) {
+			borat.NewCBORWriterWithOptions(out, opts).Marshal(in)
+		})
+	}
+
+	// the core profile passes strict decoding
+	var buf bytes.Buffer
+	w := borat.NewCBORWriterWithOptions(&buf, borat.EncOptions{Profile: borat.ProfileCore})
+	if err := w.WriteIntMap(map[int]interface{}{-1: true, 1000: "a", 1: keyed{}, 2: []interface{}{"x", -3}}); err != nil {
+		t.Fatalf("failed to write map in core profile: %v", err)
+	}
+	r := borat.NewCBORReaderWithOptions(bytes.NewReader(buf.Bytes()), borat.DecOptions{Strict: true})
+	if _, err := r.Read(); err != nil {
+		t.Errorf("failed to read core profile output in strict mode: %v", err)
+	}
+
+	buf.Reset()
+	if err := w.WriteFloat(1.5); err != nil || !bytes.Equal(buf.Bytes(), []byte{0xf9, 0x3e, 0x00}) {
+		t.Errorf("expected shortest float in core profile but got [% X], %v", buf.Bytes(), err)
+	}
+	if err := w.BeginArray(); err == nil {
+		t.Errorf("expected error beginning indefinite-length array in core profile")
+	}
+	w = borat.NewCBORWriterWithOptions(&buf, borat.EncOptions{Profile: borat.ProfileCTAP2})
+	if err := w.WriteTag(borat.TagPosBignum); err == nil {
+		t.Errorf("expected error writing tag in CTAP2 profile")
+	}
+}
+
+func TestWriteInvalidUTF8(t *testing.T) {
+	var buf bytes.Buffer
+	w := borat.NewCBORWriter(&buf)
+	for _, err := range []error{
+		w.WriteString("a\xffb"),
+		w.Marshal([]string{"\xc3"}),
+		w.WriteStringMap(map[string]interface{}{"\xc3": 1}),
+	} {
+		var ue *borat.InvalidUTF8Error
+		if !errors.As(err, &ue) {
+			t.Errorf("expected invalid UTF-8 error but got %v", err)
+		}
+	}
+
+	cborTestHarness(t, "a\xffb", []byte{0x63, 0x61, 0xff, 0x62}, func(in interface{}, out *bytes.Buffer) {
+		borat.NewCBORWriterWithOptions(out, borat.EncOptions{AllowInvalidUTF8: true}).Marshal(in)
+	})
+}
+
+func TestWriteReflected(t *testing.T) {
+	type item struct {
+		A int
+	}
+	type octet uint8
+
+	testPatterns := []struct {
+		value interface{}
+		cbor  []byte
+		core  []byte
+	}{
+		{[]item{{1}, {2}}, []byte{0x82, 0xa1, 0x61, 0x41, 0x01, 0xa1, 0x61, 0x41, 0x02}, nil},
+		{[2]string{"a", "b"}, []byte{0x82, 0x61, 0x61, 0x61, 0x62}, nil},
+		{[][]int{{1}, {}}, []byte{0x82, 0x81, 0x01, 0x80}, nil},
+		{[4]byte{1, 2, 3, 4}, []byte{0x44, 0x01, 0x02, 0x03, 0x04}, nil},
+		{[]byte{5, 6}, []byte{0x42, 0x05, 0x06}, nil},
+		{[]octet{1, 2}, []byte{0x42, 0x01, 0x02}, nil},
+		{[2]octet{3, 4}, []byte{0x42, 0x03, 0x04}, nil},
+		{map[string]int{"b": 1, "aa": 2},
+			[]byte{0xa2, 0x62, 0x61, 0x61, 0x02, 0x61, 0x62, 0x01},
+			[]byte{0xa2, 0x61, 0x62, 0x01, 0x62, 0x61, 0x61, 0x02}},
+		{map[int8]string{-1: "x", 2: "y"},
+			[]byte{0xa2, 0x20, 0x61, 0x78, 0x02, 0x61, 0x79},
+			[]byte{0xa2, 0x02, 0x61, 0x79, 0x20, 0x61, 0x78}},
+		{map[bool]int{true: 1, false: 0},
+			[]byte{0xa2, 0xf4, 0x00, 0xf5, 0x01},
+			[]byte{0xa2, 0xf4, 0x00, 0xf5, 0x01}},
+		{map[interface{}]int{"a": 1, 2: 2},
+			[]byte{0xa2, 0x61, 0x61, 0x01, 0x02, 0x02},
+			[]byte{0xa2, 0x02, 0x02, 0x61, 0x61, 0x01}},
+		{map[string][]string{"k": {"v"}}, []byte{0xa1, 0x61, 0x6b, 0x81, 0x61, 0x76}, nil},
+	}
+
+	for _, tp := range testPatterns {
+		cborTestHarness(t, tp.value, tp.cbor, func(in interface{}, out *bytes.Buffer) {
+			if err := borat.NewCBORWriter(out).Marshal(in); err != nil {
+				t.Errorf("failed to marshal %v: %v", in, err)
+			}
+		})
+		if tp.core == nil {
+			tp.core = tp.cbor
+		}
+		cborTestHarness(t, tp.value, tp.core, func(in interface{}, out *bytes.Buffer) {
+			borat.NewCBORWriterWithOptions(out, borat.EncOptions{Profile: borat.ProfileCore}).Marshal(in)
+		})
+	}
+}
+
+func TestWriteByteSliceNoCopy(t *testing.T) {
+	// plain byte slices are written as they are, without a copy
+	b := make([]byte, 1<<16)
+	w := borat.NewCBORWriter(io.Discard)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < 10; i++ {
+		if err := w.Marshal(b); err != nil {
+			t.Fatalf("failed to marshal byte slice: %v", err)
+		}
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n >= 1<<16 {
+		t.Errorf("marshaling a 64 KiB byte slice 10 times allocated %d bytes", n)
+	}
+}
+
+func TestWritePointers(t *testing.T) {
+	type item struct {
+		A *int
+		B []string
+		C map[string]int
+		D interface{}
+	}
+	n := 5
+	s := "s"
+
+	testPatterns := []struct {
+		value interface{}
+		cbor  []byte
+	}{
+		{nil, []byte{0xf6}},
+		{(*int)(nil), []byte{0xf6}},
+		{[]string(nil), []byte{0xf6}},
+		{map[string]int(nil), []byte{0xf6}},
+		{&n, []byte{0x05}},
+		{&s, []byte{0x61, 0x73}},
+		{&item{}, []byte{0xa4, 0x61, 0x41, 0xf6, 0x61, 0x42, 0xf6, 0x61, 0x43, 0xf6, 0x61, 0x44, 0xf6}},
+		{item{A: &n, B: []string{}, C: map[string]int{}, D: &n},
+			[]byte{0xa4, 0x61, 0x41, 0x05, 0x61, 0x42, 0x80, 0x61, 0x43, 0xa0, 0x61, 0x44, 0x05}},
+		{[]*int{&n, nil}, []byte{0x82, 0x05, 0xf6}},
+	}
+
+	for _, tp := range testPatterns {
+		cborTestHarness(t, tp.value, tp.cbor, func(in interface{}, out *bytes.Buffer) {
+			if err := borat.NewCBORWriter(out).Marshal(in); err != nil {
+				t.Errorf("failed to marshal %v: %v", in, err)
+			}
+		})
+	}
+}